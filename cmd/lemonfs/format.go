@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// formatFlag adds the -format flag to flags
+func formatFlag(flags *flag.FlagSet) *string {
+	return flags.String("format", "", "the format of the file: json, yaml or toml, by the extension of the file by default")
+}
+
+// fileFormat returns the format called name, or the format of path if name is empty
+func fileFormat(name string, path string) (file.Format, error) {
+	if name == "" {
+		return file.FormatOf(path), nil
+	}
+
+	return file.ParseFormat(name)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// exit codes of fsck, the same as fsck(8)
+const (
+	fsckOK          = 0
+	fsckCorrected   = 1
+	fsckUncorrected = 4
+	fsckFailed      = 8
+)
+
+// fsck checks the JSON file before it is mounted, and repairs it with -repair
+func fsck(args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "fix the problems, nodes which can't be fixed in place are moved to "+file.LostAndFound)
+	formatName := formatFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lemonfs fsck [-repair] [-format format] <json_file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fsckFailed
+	}
+
+	jsonFile := flags.Arg(0)
+
+	format, err := fileFormat(*formatName, jsonFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return fsckFailed
+	}
+
+	if *repair {
+		lockFile, err := lockJSONFile(jsonFile, "repaired")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return fsckFailed
+		}
+		defer lockFile.Close()
+	}
+
+	content, err := os.ReadFile(jsonFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return fsckFailed
+	}
+
+	// every format is checked as JSON, they store the same schema
+	jsonContent, err := format.ToJSON(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s is not a %s file: %v\n", jsonFile, strings.ToUpper(format.Name()), err)
+		return fsckFailed
+	}
+
+	problems, repaired, err := file.Check(jsonContent, *repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s is not a %s file: %v\n", jsonFile, strings.ToUpper(format.Name()), err)
+		return fsckFailed
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s: clean\n", jsonFile)
+		return fsckOK
+	}
+
+	if !*repair {
+		fmt.Printf("%s: %d problems, run with -repair to fix them\n", jsonFile, len(problems))
+		return fsckUncorrected
+	}
+
+	// the journal is discarded when the JSON file changes
+	pending, err := file.PendingChanges(jsonFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return fsckFailed
+	}
+	if pending > 0 {
+		fmt.Fprintf(os.Stderr, "%s has %d changes in its journal, mount it with -journal once to replay them before repairing\n", jsonFile, pending)
+		return fsckUncorrected
+	}
+
+	if err := file.NewFileStorage(jsonFile, format).Persist(repaired, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return fsckFailed
+	}
+
+	fmt.Printf("%s: %d problems fixed\n", jsonFile, len(problems))
+	return fsckCorrected
+}
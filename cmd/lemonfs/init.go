@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// initFile creates a JSON file holding an empty root directory, ready to be mounted
+func initFile(args []string) int {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	force := flags.Bool("force", false, "overwrite the JSON file if it exists, its content is lost")
+	formatName := formatFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lemonfs init [-force] [-format format] <json_file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	jsonFile := flags.Arg(0)
+
+	format, err := fileFormat(*formatName, jsonFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	_, err = os.Stat(jsonFile)
+	if err == nil && !*force {
+		return fail("%s already exists, use -force to overwrite it", jsonFile)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fail("%v", err)
+	}
+
+	lockFile, err := lockJSONFile(jsonFile, "created")
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer lockFile.Close()
+
+	now := uint64(time.Now().Unix())
+	root := &file.LemonDirectoryChild{
+		Type: "directory",
+		Directory: &file.LemonDirectory{
+			LemonPermission: file.NewLemonPermission(file.DefaultDirectoryMode, uint32(os.Getuid()), uint32(os.Getgid())),
+
+			Type:           "directory",
+			Content:        []file.LemonDirectoryChild{},
+			CreatedAt:      now,
+			LastAccessedAt: now,
+			LastModifiedAt: now,
+		},
+	}
+
+	if err := file.NewFileStorage(jsonFile, format).Persist(root, nil); err != nil {
+		return fail("%v", err)
+	}
+
+	fmt.Printf("%s: created, mount it with lemonfs mount %s <mount_point>\n", jsonFile, jsonFile)
+	return 0
+}
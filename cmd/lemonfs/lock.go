@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// lockJSONFile locks a JSON file before a command writes it, a mounted JSON file would be overwritten by lemonfs.
+// done is what the command does to the file for the error, like "created". The lock is released when the file is closed.
+func lockJSONFile(jsonFile string, done string) (*os.File, error) {
+	lockFile, err := file.Lock(jsonFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s can't be %s: %w", jsonFile, done, err)
+	}
+
+	return lockFile, nil
+}
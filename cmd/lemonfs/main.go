@@ -1,68 +1,58 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
-
-	"github.com/hanwen/go-fuse/v2/fs"
-	"github.com/hanwen/go-fuse/v2/fuse"
-	"github.com/lemonnekogh/lemonfs/pkg/file"
-	"github.com/lemonnekogh/lemonfs/pkg/inode"
+	"strings"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: lemonfs <json_file> <mount_point>")
-		os.Exit(1)
-	}
+const usage = `Usage: lemonfs <command> [flags] [arguments]
 
-	jsonFile := os.Args[1]
-	mountPoint := os.Args[2]
+Commands:
+  mount    mount a JSON file as a filesystem
+  unmount  unmount a mounted JSON file
+  init     create a JSON file holding an empty directory
+  fsck     check and repair a JSON file
+  import   create a JSON file from a directory
+  export   write the tree of a JSON file into a directory
+  version  print the version of lemonfs
 
-	jsonContent, err := os.ReadFile(jsonFile)
-	if err != nil {
-		log.Fatal(err)
-	}
+Run "lemonfs <command> -h" for the flags of a command.
+"lemonfs [flags] <json_file> <mount_point>" is the same as "lemonfs mount".
+`
 
-	jsonRoot := &file.LemonDirectoryChild{}
-	err = json.Unmarshal(jsonContent, jsonRoot)
-	if err != nil {
-		log.Fatal(err)
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
-	jsonRoot.TargetFile = jsonFile
-	jsonRoot.ApplyParentAndTarget(nil)
-	if jsonRoot.File == nil && jsonRoot.Directory == nil {
-		log.Println("jsonRoot is nil, create empty directory")
 
-		jsonRoot.Directory = &file.LemonDirectory{
-			Type:    "directory",
-			Content: []file.LemonDirectoryChild{},
+	command, args := os.Args[1], os.Args[2:]
+
+	switch command {
+	case "mount":
+		os.Exit(mount(args))
+	case "unmount", "umount":
+		os.Exit(unmount(args))
+	case "init":
+		os.Exit(initFile(args))
+	case "fsck":
+		os.Exit(fsck(args))
+	case "import":
+		os.Exit(importDir(args))
+	case "export":
+		os.Exit(exportDir(args))
+	case "version", "-version", "--version":
+		os.Exit(printVersion(args))
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		// mount without a command, like before the commands existed
+		if strings.HasPrefix(command, "-") || len(args) > 0 {
+			os.Exit(mount(os.Args[1:]))
 		}
-		jsonRoot.WriteToFile()
-	}
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
-	defer cancel()
-
-	rootInode := inode.NewLemonInode(jsonRoot, nil)
-
-	server, err := fs.Mount(mountPoint, rootInode, &fs.Options{
-		MountOptions: fuse.MountOptions{
-			Debug: true,
-		},
-	}) // It will call OnAdd
-	if err != nil {
-		log.Fatal(err)
-	}
 
-	<-ctx.Done()
-	err = server.Unmount()
-	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "lemonfs: unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
 	}
 }
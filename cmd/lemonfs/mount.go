@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/lemonnekogh/lemonfs/pkg/inode"
+)
+
+// readyEnv is set for a lemonfs started in the background, it writes to the file descriptor 3 once it has mounted
+const readyEnv = "LEMONFS_READY_FD"
+
+// mount mounts the JSON file and serves it until it is unmounted or interrupted
+func mount(args []string) int {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	defaultPermissions := flags.Bool("default-permissions", false, "let the kernel check permissions with the default_permissions mount option")
+	capacity := flags.Uint64("capacity", inode.DefaultCapacity, "capacity in bytes reported to df")
+	syncWrites := flags.Bool("sync", false, "write every change to the JSON file immediately instead of caching them")
+	flushInterval := flags.Duration("flush-interval", file.DefaultFlushInterval, "how often cached changes are written to the JSON file")
+	journal := flags.Bool("journal", false, "record every change in a journal next to the JSON file, which is written at checkpoints only")
+	readOnly := flags.Bool("read-only", false, "mount read-only, the JSON file is never written nor locked, so a writer can mount it at the same time")
+	reloadInterval := flags.Duration("reload-interval", file.DefaultReloadInterval, "how often the JSON file is checked for changes made by other processes, 0 disables reloading")
+	debug := flags.Bool("debug", false, "log every operation and FUSE request, implies -foreground")
+	allowOther := flags.Bool("allow-other", false, "let other users access the filesystem, needs user_allow_other in /etc/fuse.conf")
+	uid := flags.Int("uid", -1, "report every node as owned by this uid instead of the owner in the JSON file")
+	gid := flags.Int("gid", -1, "report every node as owned by this gid instead of the group in the JSON file")
+	entryTimeout := flags.Duration("entry-timeout", time.Second, "how long the kernel caches names")
+	attrTimeout := flags.Duration("attr-timeout", time.Second, "how long the kernel caches attributes")
+	var foreground bool
+	flags.BoolVar(&foreground, "foreground", false, "stay in the foreground instead of running in the background once mounted")
+	flags.BoolVar(&foreground, "f", false, "shorthand for -foreground")
+	fsName := flags.String("fsname", "", "the source shown by mount and df, the path of the JSON file by default")
+	formatName := formatFlag(flags)
+	data := flags.Bool("data", false, "mount an arbitrary JSON document, objects and arrays are directories and the other values are files holding their JSON, "+
+		"every change is written immediately and the document is not reloaded")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lemonfs mount [flags] <json_file> <mount_point>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	if *syncWrites && *journal {
+		return fail("-sync and -journal can't be used together")
+	}
+
+	if *readOnly && *journal {
+		return fail("-read-only and -journal can't be used together")
+	}
+
+	if *data && *journal {
+		return fail("-data and -journal can't be used together")
+	}
+
+	if *data && *formatName != "" && *formatName != "json" {
+		return fail("-data mounts JSON documents only")
+	}
+
+	if *uid < -1 || *gid < -1 {
+		return fail("-uid and -gid must not be negative")
+	}
+
+	jsonFile := flags.Arg(0)
+	mountPoint := flags.Arg(1)
+
+	format, err := fileFormat(*formatName, jsonFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if err := checkMountPoint(mountPoint, jsonFile); err != nil {
+		return fail("%v", err)
+	}
+
+	if !foreground && !*debug {
+		return background(args)
+	}
+
+	// only one process may write the JSON file, readers don't take the lock
+	if !*readOnly {
+		lockFile, err := file.Lock(jsonFile)
+		if errors.Is(err, file.ErrLocked) {
+			return fail("%s is already mounted: %v, use -read-only to mount it without writing", jsonFile, err)
+		}
+		if err != nil {
+			return fail("%v", err)
+		}
+		defer lockFile.Close()
+	}
+
+	// the journal is replayed with -journal only, its changes would be lost at the next write of the JSON file
+	if !*journal {
+		if err := checkJournal(jsonFile, *readOnly); err != nil {
+			return fail("%v", err)
+		}
+	}
+
+	// a read-only tree is never changed, so the storage never writes
+	var storage file.Storage
+	var fileStorage *file.FileStorage
+	if *data {
+		storage = file.NewDataStorage(jsonFile)
+	} else {
+		fileStorage = file.NewFileStorage(jsonFile, format)
+		storage = fileStorage
+
+		if !*syncWrites && !*readOnly {
+			writeBack := file.NewWriteBack(fileStorage, *flushInterval)
+
+			if *journal {
+				j, err := file.OpenJournal(file.JournalPath(jsonFile))
+				if err != nil {
+					return fail("%v", err)
+				}
+				writeBack.SetJournal(j)
+			}
+		}
+	}
+
+	jsonRoot, err := storage.Load()
+	if err != nil && *data {
+		return fail("%s can't be loaded: %v", jsonFile, err)
+	}
+	if err != nil {
+		return fail("%s can't be loaded: %v, run lemonfs fsck to check it", jsonFile, err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	rootInode := inode.NewLemonInode(jsonRoot, nil)
+	rootInode.Options.DefaultPermissions = *defaultPermissions
+	rootInode.Options.Capacity = *capacity
+	rootInode.Options.ReadOnly = *readOnly
+	if *uid >= 0 {
+		owner := uint32(*uid)
+		rootInode.Options.Uid = &owner
+	}
+	if *gid >= 0 {
+		group := uint32(*gid)
+		rootInode.Options.Gid = &group
+	}
+
+	if *fsName == "" {
+		*fsName, _ = filepath.Abs(jsonFile)
+	}
+
+	mountOptions := fuse.MountOptions{
+		Debug:      *debug,
+		AllowOther: *allowOther,
+		FsName:     *fsName,
+		Name:       "lemonfs",
+	}
+	if *defaultPermissions {
+		mountOptions.Options = append(mountOptions.Options, "default_permissions")
+	}
+	if *readOnly {
+		mountOptions.Options = append(mountOptions.Options, "ro")
+	}
+
+	var watcher *file.Watcher
+	if fileStorage != nil && *reloadInterval > 0 {
+		watcher, err = file.NewWatcher(fileStorage, jsonRoot, *reloadInterval, rootInode.Reloaded)
+		if err != nil {
+			return fail("%v", err)
+		}
+	}
+
+	// the operations are logged for debugging only
+	if !*debug {
+		log.SetOutput(io.Discard)
+	}
+
+	server, err := fs.Mount(mountPoint, rootInode, &fs.Options{
+		MountOptions: mountOptions,
+		EntryTimeout: entryTimeout,
+		AttrTimeout:  attrTimeout,
+	}) // It will call OnAdd
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if watcher != nil {
+		watcher.Start()
+	}
+
+	notifyReady()
+
+	// stop when unmounted by lemonfs unmount or fusermount too
+	unmounted := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(unmounted)
+		cancel()
+	}()
+
+	<-ctx.Done()
+
+	if watcher != nil {
+		watcher.Close()
+	}
+
+	var unmountErr error
+	select {
+	case <-unmounted:
+	default:
+		unmountErr = server.Unmount()
+	}
+
+	// write the cached changes even if the mount point is busy
+	if err := storage.Close(); err != nil {
+		return fail("%v", err)
+	}
+
+	if unmountErr != nil {
+		return fail("%v", unmountErr)
+	}
+
+	return 0
+}
+
+// checkJournal refuses to mount a JSON file whose journal has changes which haven't been checkpointed,
+// they are left by a lemonfs mounted with -journal which has stopped. The journal of a JSON file mounted by
+// a running lemonfs is checkpointed by it, a reader shows the JSON file as it has last been written.
+func checkJournal(jsonFile string, readOnly bool) error {
+	pending, err := file.PendingChanges(jsonFile)
+	if err != nil {
+		return err
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	if readOnly {
+		lockFile, err := file.Lock(jsonFile)
+		if errors.Is(err, file.ErrLocked) {
+			return nil
+		}
+		if err == nil {
+			lockFile.Close()
+		}
+	}
+
+	return fmt.Errorf("%s has %d changes in its journal, mount it with -journal once to replay them", jsonFile, pending)
+}
+
+// checkMountPoint makes sure that mountPoint is a directory lemonfs can be mounted on
+func checkMountPoint(mountPoint, jsonFile string) error {
+	info, err := os.Stat(mountPoint)
+	if errors.Is(err, syscall.ENOTCONN) {
+		return fmt.Errorf("%s is still mounted by a lemonfs which has stopped, run lemonfs unmount %s first", mountPoint, mountPoint)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("the mount point %s does not exist", mountPoint)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("the mount point %s is not a directory", mountPoint)
+	}
+
+	absMountPoint, err := filepath.Abs(mountPoint)
+	if err != nil {
+		return err
+	}
+
+	// a mount point on another device than its parent is mounted already
+	if parent, err := os.Stat(filepath.Dir(absMountPoint)); err == nil && absMountPoint != "/" {
+		if info.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev {
+			return fmt.Errorf("%s is already a mount point", mountPoint)
+		}
+	}
+
+	// lemonfs would read the JSON file through itself
+	absJSONFile, err := filepath.Abs(jsonFile)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(absJSONFile, absMountPoint+string(filepath.Separator)) {
+		return fmt.Errorf("the JSON file %s is inside the mount point %s", jsonFile, mountPoint)
+	}
+
+	return nil
+}
+
+// background starts lemonfs again in the foreground of a new session and returns once it has mounted,
+// it shares stderr so errors are printed where lemonfs has been started
+func background(args []string) int {
+	executable, err := os.Executable()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(executable, append([]string{"mount", "-foreground"}, args...)...)
+	cmd.Env = append(os.Environ(), readyEnv+"=3")
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// the pipe is closed without a message if lemonfs fails to mount
+	message, _ := io.ReadAll(ready)
+	if string(message) != "ready\n" {
+		if err := cmd.Wait(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return exitErr.ExitCode()
+			}
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// notifyReady tells the process which has started lemonfs in the background that it has mounted
+func notifyReady() {
+	if os.Getenv(readyEnv) == "" {
+		return
+	}
+
+	ready := os.NewFile(3, "ready")
+	ready.Write([]byte("ready\n"))
+	ready.Close()
+}
+
+// fail prints an error of a command and returns its exit code
+func fail(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "lemonfs: "+format+"\n", args...)
+	return 1
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// importDir creates a JSON file holding the tree of a directory
+func importDir(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	force := flags.Bool("force", false, "overwrite the JSON file if it exists, its content is lost")
+	formatName := formatFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lemonfs import [-force] [-format format] <dir> <json_file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	dir, jsonFile := flags.Arg(0), flags.Arg(1)
+
+	format, err := fileFormat(*formatName, jsonFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	_, err = os.Stat(jsonFile)
+	if err == nil && !*force {
+		return fail("%s already exists, use -force to overwrite it", jsonFile)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fail("%v", err)
+	}
+
+	lockFile, err := lockJSONFile(jsonFile, "created")
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer lockFile.Close()
+
+	root, skipped, err := file.Import(dir)
+	if err != nil {
+		return fail("%s can't be imported: %v", dir, err)
+	}
+
+	for _, path := range skipped {
+		fmt.Fprintf(os.Stderr, "%s: skipped, only files, directories and symlinks can be imported\n", path)
+	}
+
+	if err := file.NewFileStorage(jsonFile, format).Persist(root, nil); err != nil {
+		return fail("%v", err)
+	}
+
+	fmt.Printf("%s: imported into %s\n", dir, jsonFile)
+	return 0
+}
+
+// exportDir writes the tree of a JSON file into a directory
+func exportDir(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := formatFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lemonfs export [-format format] <json_file> <dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	jsonFile, dir := flags.Arg(0), flags.Arg(1)
+
+	format, err := fileFormat(*formatName, jsonFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// the changes in the journal are not in the JSON file yet
+	pending, err := file.PendingChanges(jsonFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if pending > 0 {
+		return fail("%s has %d changes in its journal, mount it with -journal once to replay them before exporting", jsonFile, pending)
+	}
+
+	root, err := file.NewFileStorage(jsonFile, format).Load()
+	if err != nil {
+		return fail("%s can't be loaded: %v, run lemonfs fsck to check it", jsonFile, err)
+	}
+
+	if err := file.Export(root, dir); err != nil {
+		return fail("%s can't be exported: %v", jsonFile, err)
+	}
+
+	fmt.Printf("%s: exported to %s\n", jsonFile, dir)
+	return 0
+}
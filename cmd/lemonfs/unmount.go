@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// unmount unmounts a mount point, the lemonfs serving it writes the cached changes and exits
+func unmount(args []string) int {
+	flags := flag.NewFlagSet("unmount", flag.ExitOnError)
+	lazy := flags.Bool("lazy", false, "detach the mount point now and unmount it when it is not busy anymore")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lemonfs unmount [-lazy] <mount_point>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	mountPoint := flags.Arg(0)
+
+	cmd := unmountCommand(mountPoint, *lazy)
+	if cmd == nil {
+		return fail("neither fusermount nor umount is found")
+	}
+
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fail("failed to unmount %s: %v", mountPoint, err)
+	}
+
+	return 0
+}
+
+// unmountCommand returns the command unmounting mountPoint, fusermount lets users unmount without root on Linux
+func unmountCommand(mountPoint string, lazy bool) *exec.Cmd {
+	if runtime.GOOS == "linux" {
+		for _, name := range []string{"fusermount3", "fusermount"} {
+			if path, err := exec.LookPath(name); err == nil {
+				if lazy {
+					return exec.Command(path, "-u", "-z", mountPoint)
+				}
+				return exec.Command(path, "-u", mountPoint)
+			}
+		}
+	}
+
+	path, err := exec.LookPath("umount")
+	if err != nil {
+		return nil
+	}
+
+	if lazy && runtime.GOOS == "linux" {
+		return exec.Command(path, "-l", mountPoint)
+	}
+
+	// umount of macOS and FreeBSD can't detach a busy mount point, it can only force it
+	if lazy {
+		return exec.Command(path, "-f", mountPoint)
+	}
+
+	return exec.Command(path, mountPoint)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is set when building a release with -ldflags "-X main.version=v1.0.0",
+// the version of the module is used otherwise
+var version = ""
+
+func printVersion(args []string) int {
+	if len(args) != 0 {
+		fmt.Println("Usage: lemonfs version")
+		return 2
+	}
+
+	v := version
+	if v == "" {
+		v = "(devel)"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+			v = info.Main.Version
+		}
+	}
+
+	fmt.Printf("lemonfs %s %s %s/%s\n", v, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
@@ -3,15 +3,16 @@ module github.com/lemonnekogh/lemonfs
 go 1.23.4
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/hanwen/go-fuse/v2 v2.7.2
 	github.com/samber/lo v1.47.0
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
 	golang.org/x/text v0.16.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
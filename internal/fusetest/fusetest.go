@@ -0,0 +1,36 @@
+// Package fusetest mounts trees for the tests of the FUSE operations
+package fusetest
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Mount mounts root on a temporary directory and returns it, the tree is unmounted when the test finishes
+func Mount(t testing.TB, root fs.InodeEmbedder) string {
+	mountPoint, _ := MountServer(t, root, nil)
+
+	return mountPoint
+}
+
+// MountServer mounts root like Mount with options, which may be nil, and returns the server to unmount it earlier.
+// The debug log of go-fuse is always enabled.
+func MountServer(t testing.TB, root fs.InodeEmbedder, options *fs.Options) (string, *fuse.Server) {
+	t.Helper()
+
+	if options == nil {
+		options = &fs.Options{}
+	}
+	options.MountOptions.Debug = true
+
+	mountPoint := t.TempDir()
+	server, err := fs.Mount(mountPoint, root, options)
+	if err != nil {
+		t.Fatalf("mount %s: %v", mountPoint, err)
+	}
+	t.Cleanup(func() { server.Unmount() })
+
+	return mountPoint, server
+}
@@ -0,0 +1,129 @@
+package file
+
+import (
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/samber/lo"
+)
+
+// FileType returns the file type bits of the mode of the node
+func (c *LemonDirectoryChild) FileType() uint32 {
+	switch {
+	case c.IsSymlink():
+		return fuse.S_IFLNK
+	case c.IsDirectory():
+		return fuse.S_IFDIR
+	default:
+		return fuse.S_IFREG
+	}
+}
+
+// FillAttr fills the attributes of the node into out
+func (c *LemonDirectoryChild) FillAttr(out *fuse.Attr) {
+	if c.IsFile() {
+		out.Size = uint64(len(c.File.Content))
+		out.Atime = c.File.LastAccessedAt
+		out.Mtime = c.File.LastModifiedAt
+		out.Ctime = c.File.CreatedAt
+		out.Mode = fuse.S_IFREG | c.File.Perm(DefaultFileMode)
+		out.Nlink = c.File.Links()
+	}
+
+	if c.IsDirectory() {
+		out.Atime = c.Directory.LastAccessedAt
+		out.Mtime = c.Directory.LastModifiedAt
+		out.Ctime = c.Directory.CreatedAt
+		out.Mode = fuse.S_IFDIR | c.Directory.Perm(DefaultDirectoryMode)
+		// . and the entry in the parent, and .. of every sub directory
+		out.Nlink = 2 + uint32(lo.CountBy(c.Directory.Content, func(child LemonDirectoryChild) bool {
+			return child.IsDirectory()
+		}))
+	}
+
+	if c.IsSymlink() {
+		out.Size = uint64(len(c.Symlink.Target))
+		out.Atime = c.Symlink.LastAccessedAt
+		out.Mtime = c.Symlink.LastModifiedAt
+		out.Ctime = c.Symlink.CreatedAt
+		// permission bits of symbolic links are never used
+		out.Mode = fuse.S_IFLNK | 0777
+		out.Nlink = 1
+	}
+
+	out.Uid, out.Gid = c.Permission().Owner()
+}
+
+// SetAttr applies the attributes marked as valid in in to the node, other attributes are left untouched
+func (c *LemonDirectoryChild) SetAttr(in *fuse.SetAttrIn) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if c.IsDirectory() {
+			return syscall.EISDIR
+		}
+
+		if !c.IsFile() {
+			return syscall.EINVAL
+		}
+
+		c.File.Truncate(size)
+	}
+
+	atime, mtime, ctime := c.times()
+
+	if t, ok := in.GetATime(); ok {
+		*atime = uint64(t.Unix())
+	}
+
+	if t, ok := in.GetMTime(); ok {
+		*mtime = uint64(t.Unix())
+	}
+
+	if t, ok := in.GetCTime(); ok {
+		*ctime = uint64(t.Unix())
+	}
+
+	permission := c.Permission()
+
+	if mode, ok := in.GetMode(); ok {
+		permission.Chmod(mode)
+	}
+
+	uid, gid := permission.Owner()
+	if newUid, ok := in.GetUID(); ok {
+		uid = newUid
+	}
+	if newGid, ok := in.GetGID(); ok {
+		gid = newGid
+	}
+	if in.Valid&(fuse.FATTR_UID|fuse.FATTR_GID) != 0 {
+		permission.Chown(uid, gid)
+	}
+
+	return 0
+}
+
+// times returns pointers to the access, modification and change time of the node
+func (c *LemonDirectoryChild) times() (atime, mtime, ctime *uint64) {
+	if c.IsFile() {
+		return &c.File.LastAccessedAt, &c.File.LastModifiedAt, &c.File.CreatedAt
+	}
+
+	if c.IsSymlink() {
+		return &c.Symlink.LastAccessedAt, &c.Symlink.LastModifiedAt, &c.Symlink.CreatedAt
+	}
+
+	return &c.Directory.LastAccessedAt, &c.Directory.LastModifiedAt, &c.Directory.CreatedAt
+}
+
+// Permission returns the permission bits and the ownership of the node
+func (c *LemonDirectoryChild) Permission() *LemonPermission {
+	if c.IsFile() {
+		return &c.File.LemonPermission
+	}
+
+	if c.IsSymlink() {
+		return &c.Symlink.LemonPermission
+	}
+
+	return &c.Directory.LemonPermission
+}
@@ -0,0 +1,376 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// DataStorage mounts an arbitrary JSON document instead of a tree in the lemonfs schema.
+// Objects are directories with an entry per property, arrays are directories with an entry per index,
+// and the other values are files holding their JSON, followed by a newline.
+//
+// Every change is written back into the document. A file holding invalid JSON is usually being rewritten,
+// so its last valid value is written instead, or it is left out if it never held one, until it is valid again.
+// Flush reports the invalid file to close.
+// Directories created by mkdir are objects, the elements of an array are renumbered when the document is loaded again.
+// Symlinks can't be stored, extended attributes, permissions and timestamps are kept in memory only.
+// Properties which aren't valid file names are escaped, see dataName.
+type DataStorage struct {
+	path string
+
+	lock sync.Mutex
+	// arrays are the directories which are arrays in the document
+	arrays map[*LemonDirectory]bool
+	// values are the values of the files in the document as it was last loaded or written
+	values map[*LemonFile][]byte
+	// invalid is the error of the last change if a file with invalid JSON has been held back
+	invalid error
+
+	// stored is the size of the document when it was last loaded or written
+	stored atomic.Uint64
+}
+
+// ErrInvalidData is returned for a tree which can't be written as a JSON document
+var ErrInvalidData = fmt.Errorf("invalid JSON document: %w", syscall.EINVAL)
+
+func NewDataStorage(path string) *DataStorage {
+	return &DataStorage{path: path, arrays: map[*LemonDirectory]bool{}, values: map[*LemonFile][]byte{}}
+}
+
+// Path returns the path of the JSON document
+func (s *DataStorage) Path() string {
+	return s.path
+}
+
+// Load reads the document, its root must be an object or an array
+func (s *DataStorage) Load() (*LemonDirectoryChild, error) {
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the decoder doesn't check what follows the document
+	var document json.RawMessage
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	now := uint64(info.ModTime().Unix())
+	s.stored.Store(uint64(len(content)))
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	root, err := s.decode(decoder, "", now)
+	if err != nil {
+		return nil, err
+	}
+	if !root.IsDirectory() {
+		return nil, errors.New("the root is not an object or an array")
+	}
+
+	root.ApplyParent(nil)
+	root.SetStorage(s)
+
+	return root, nil
+}
+
+// decode reads the next value from decoder as a node called name
+func (s *DataStorage) decode(decoder *json.Decoder, name string, now uint64) (*LemonDirectoryChild, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		// scalars are stored as their compact JSON, numbers as they are written in the document
+		value := &bytes.Buffer{}
+		encoder := json.NewEncoder(value)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(token); err != nil {
+			return nil, err
+		}
+
+		file := &LemonFile{
+			Type:           "file",
+			Name:           name,
+			Content:        value.String(),
+			CreatedAt:      now,
+			LastAccessedAt: now,
+			LastModifiedAt: now,
+		}
+		s.values[file] = bytes.TrimSpace(value.Bytes())
+
+		return &LemonDirectoryChild{Type: "file", File: file}, nil
+	}
+
+	dir := &LemonDirectory{
+		Type:           "directory",
+		Name:           name,
+		Content:        []LemonDirectoryChild{},
+		CreatedAt:      now,
+		LastAccessedAt: now,
+		LastModifiedAt: now,
+	}
+	if delim == '[' {
+		s.arrays[dir] = true
+	}
+
+	names := map[string]bool{}
+	for decoder.More() {
+		childName := strconv.Itoa(len(dir.Content))
+
+		if delim == '{' {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			if names[key.(string)] {
+				return nil, fmt.Errorf("the property %q is duplicated", key)
+			}
+			names[key.(string)] = true
+			childName = dataName(key.(string))
+		}
+
+		child, err := s.decode(decoder, childName, now)
+		if err != nil {
+			return nil, err
+		}
+		dir.Content = append(dir.Content, *child)
+	}
+
+	// the closing delimiter
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	return &LemonDirectoryChild{Type: "directory", Directory: dir}, nil
+}
+
+// Persist writes the tree back into the document. Changes which can't be stored are rejected with ErrInvalidData,
+// the value of a file with invalid JSON is held back until it is valid.
+func (s *DataStorage) Persist(root *LemonDirectoryChild, changes []*Change) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	encoder := &dataEncoder{arrays: s.arrays, stored: s.values, values: map[*LemonFile][]byte{}}
+	if err := encoder.encode(root); err != nil {
+		return err
+	}
+
+	s.invalid = encoder.invalid
+
+	content := &bytes.Buffer{}
+	if err := json.Indent(content, encoder.buf.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	content.WriteByte('\n')
+
+	if err := writeFileAtomic(s.path, content.Bytes()); err != nil {
+		return err
+	}
+	s.stored.Store(uint64(content.Len()))
+	s.values = encoder.values
+
+	return nil
+}
+
+func (s *DataStorage) size() uint64 {
+	return s.stored.Load()
+}
+
+// dataEncoder writes a tree as a compact JSON document
+type dataEncoder struct {
+	arrays map[*LemonDirectory]bool
+	// stored are the values of the files in the document, values are the values written by the encoder
+	stored map[*LemonFile][]byte
+	values map[*LemonFile][]byte
+	buf    bytes.Buffer
+	// invalid is the error of the first file which doesn't hold valid JSON, the encoding goes on to check the others
+	invalid error
+}
+
+// value returns the value of a file, a file with invalid JSON keeps its stored value.
+// It returns false for a file which has never held valid JSON, it is left out of the document.
+func (e *dataEncoder) value(node *LemonDirectoryChild) ([]byte, bool) {
+	value := bytes.TrimSpace([]byte(node.File.Content))
+	if json.Valid(value) {
+		return value, true
+	}
+
+	if e.invalid == nil {
+		e.invalid = fmt.Errorf("%s: not a JSON value: %w", node.Path(), ErrInvalidData)
+	}
+
+	value, ok := e.stored[node.File]
+
+	return value, ok
+}
+
+// omitted reports whether node is a file which is left out of the document
+func (e *dataEncoder) omitted(node *LemonDirectoryChild) bool {
+	if !node.IsFile() {
+		return false
+	}
+
+	_, ok := e.value(node)
+
+	return !ok
+}
+
+func (e *dataEncoder) encode(node *LemonDirectoryChild) error {
+	switch {
+	case node.IsFile():
+		value, _ := e.value(node)
+		e.values[node.File] = value
+
+		return json.Compact(&e.buf, value)
+	case node.IsDirectory():
+		if e.arrays[node.Directory] {
+			return e.encodeArray(node)
+		}
+
+		e.buf.WriteByte('{')
+		properties := map[string]bool{}
+		for i := range node.Directory.Content {
+			child := &node.Directory.Content[i]
+			if e.omitted(child) {
+				continue
+			}
+			if len(properties) > 0 {
+				e.buf.WriteByte(',')
+			}
+
+			// names which are escaped differently may be the same property
+			property := dataProperty(child.Name())
+			if properties[property] {
+				return fmt.Errorf("%s: the property %q is duplicated: %w", child.Path(), property, ErrInvalidData)
+			}
+			properties[property] = true
+
+			key, err := json.Marshal(property)
+			if err != nil {
+				return err
+			}
+			e.buf.Write(key)
+			e.buf.WriteByte(':')
+
+			if err := e.encode(child); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte('}')
+
+		return nil
+	default:
+		return fmt.Errorf("%s: a symlink can't be stored: %w", node.Path(), ErrInvalidData)
+	}
+}
+
+// encodeArray writes the entries of an array ordered by their index, the gaps left by removed entries are closed
+func (e *dataEncoder) encodeArray(node *LemonDirectoryChild) error {
+	type element struct {
+		index int
+		node  *LemonDirectoryChild
+	}
+
+	elements := make([]element, 0, len(node.Directory.Content))
+	for i := range node.Directory.Content {
+		child := &node.Directory.Content[i]
+
+		index, err := strconv.Atoi(child.Name())
+		if err != nil || index < 0 || strconv.Itoa(index) != child.Name() {
+			return fmt.Errorf("%s: the entries of an array must be named by their index: %w", child.Path(), ErrInvalidData)
+		}
+
+		elements = append(elements, element{index: index, node: child})
+	}
+
+	slices.SortFunc(elements, func(a, b element) int {
+		return a.index - b.index
+	})
+
+	e.buf.WriteByte('[')
+	written := 0
+	for _, element := range elements {
+		if e.omitted(element.node) {
+			continue
+		}
+		if written > 0 {
+			e.buf.WriteByte(',')
+		}
+		written++
+
+		if err := e.encode(element.node); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+
+	return nil
+}
+
+// dataName escapes a property into a file name. Slashes, NUL bytes and percent signs are percent-encoded,
+// the dots of "." and ".." too, and the empty property is called "%".
+func dataName(property string) string {
+	switch property {
+	case "":
+		return "%"
+	case ".", "..":
+		return strings.ReplaceAll(property, ".", "%2E")
+	}
+
+	return strings.NewReplacer("%", "%25", "/", "%2F", "\x00", "%00").Replace(property)
+}
+
+// dataProperty reverses dataName, a percent sign which doesn't start an escape is kept as it is,
+// so files named by the user don't need to be escaped
+func dataProperty(name string) string {
+	if name == "%" {
+		return ""
+	}
+
+	property := strings.Builder{}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) {
+			if b, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				property.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		property.WriteByte(name[i])
+	}
+
+	return property.String()
+}
+
+// Flush reports the file with invalid JSON which has been held back at the last change
+func (s *DataStorage) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.invalid
+}
+
+func (s *DataStorage) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,224 @@
+package file_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/stretchr/testify/require"
+)
+
+func loadData(t *testing.T, document string) (*file.DataStorage, *file.LemonDirectoryChild) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	r.NoError(os.WriteFile(path, []byte(document), 0644))
+
+	storage := file.NewDataStorage(path)
+	root, err := storage.Load()
+	r.NoError(err)
+
+	return storage, root
+}
+
+func findChild(dir *file.LemonDirectoryChild, name string) *file.LemonDirectoryChild {
+	for i := range dir.Directory.Content {
+		if dir.Directory.Content[i].Name() == name {
+			return &dir.Directory.Content[i]
+		}
+	}
+
+	return nil
+}
+
+func childNames(dir *file.LemonDirectoryChild) []string {
+	names := []string{}
+	for i := range dir.Directory.Content {
+		names = append(names, dir.Directory.Content[i].Name())
+	}
+
+	return names
+}
+
+func TestDataStorage(t *testing.T) {
+	t.Run("load", func(t *testing.T) {
+		r := require.New(t)
+
+		_, root := loadData(t, `{"name": "demo", "n": 1.50, "tags": ["a", "b<c"], "deps": {"x": null}, "ok": true}`)
+
+		r.Equal([]string{"name", "n", "tags", "deps", "ok"}, childNames(root))
+		r.Equal("\"demo\"\n", findChild(root, "name").File.Content)
+		// numbers are kept as they are written
+		r.Equal("1.50\n", findChild(root, "n").File.Content)
+		r.Equal("true\n", findChild(root, "ok").File.Content)
+
+		tags := findChild(root, "tags")
+		r.True(tags.IsDirectory())
+		r.Equal([]string{"0", "1"}, childNames(tags))
+		r.Equal("\"b<c\"\n", findChild(tags, "1").File.Content)
+
+		r.Equal("null\n", findChild(findChild(root, "deps"), "x").File.Content)
+	})
+
+	t.Run("invalid documents", func(t *testing.T) {
+		for _, document := range []string{`"scalar"`, `{"a": 1, "a": 2}`, `{"a": 1} {}`, `{`} {
+			path := filepath.Join(t.TempDir(), "data.json")
+			require.NoError(t, os.WriteFile(path, []byte(document), 0644))
+
+			_, err := file.NewDataStorage(path).Load()
+			require.Error(t, err, document)
+		}
+	})
+
+	t.Run("escaped properties", func(t *testing.T) {
+		r := require.New(t)
+
+		document := `{"exports": {"./feature": 1, "": 2, ".": 3, "..": 4, "50%": 5, "a\u0000b": 6, "100%2F": 7}}`
+		storage, root := loadData(t, document)
+
+		exports := findChild(root, "exports")
+		r.Equal([]string{".%2Ffeature", "%", "%2E", "%2E%2E", "50%25", "a%00b", "100%252F"}, childNames(exports))
+
+		r.NoError(root.Persist())
+		content, err := os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(document, string(content))
+
+		// files created with an escape are stored unescaped, other percent signs are kept
+		exports.Directory.Content = append(exports.Directory.Content,
+			file.LemonDirectoryChild{Type: "file", File: &file.LemonFile{Type: "file", Name: "b%2Fc", Content: "8"}, Parent: exports},
+			file.LemonDirectoryChild{Type: "file", File: &file.LemonFile{Type: "file", Name: "5%", Content: "9"}, Parent: exports},
+		)
+		r.NoError(root.Persist())
+		content, err = os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(`{"exports": {"./feature": 1, "": 2, ".": 3, "..": 4, "50%": 5, "a\u0000b": 6, "100%2F": 7, "b/c": 8, "5%": 9}}`, string(content))
+
+		// names which are the same property can't be stored
+		exports.Directory.Content = append(exports.Directory.Content,
+			file.LemonDirectoryChild{Type: "file", File: &file.LemonFile{Type: "file", Name: "b%2fc", Content: "10"}, Parent: exports},
+		)
+		r.ErrorIs(root.Persist(), file.ErrInvalidData)
+	})
+
+	t.Run("write back", func(t *testing.T) {
+		r := require.New(t)
+
+		storage, root := loadData(t, `{"name": "demo", "tags": ["a", "b", "c"], "deps": {}}`)
+
+		findChild(root, "name").File.Content = "\"renamed\"\n"
+
+		// removed elements of an array close the gap
+		tags := findChild(root, "tags")
+		tags.Directory.Content = append(tags.Directory.Content[:1], tags.Directory.Content[2:]...)
+
+		deps := findChild(root, "deps")
+		deps.Directory.Content = append(deps.Directory.Content, file.LemonDirectoryChild{
+			Type:   "file",
+			File:   &file.LemonFile{Type: "file", Name: "x", Content: `{"version": 1}`},
+			Parent: deps,
+		})
+
+		r.NoError(root.Persist())
+		r.NoError(root.Flush())
+
+		content, err := os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(`{"name": "renamed", "tags": ["a", "c"], "deps": {"x": {"version": 1}}}`, string(content))
+
+		used, _, err := root.Usage()
+		r.NoError(err)
+		r.Equal(uint64(len(content)), used)
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		r := require.New(t)
+
+		storage, root := loadData(t, `{"a": 1, "b": 2}`)
+
+		// a file being rewritten keeps its value until it holds JSON again, the other changes are written
+		findChild(root, "a").File.Content = ""
+		r.NoError(root.Persist())
+		findChild(root, "b").File.Content = "3"
+		r.NoError(root.Persist())
+
+		err := root.Flush()
+		r.ErrorIs(err, file.ErrInvalidData)
+		r.Equal(syscall.EINVAL, file.Errno(err))
+		r.Contains(err.Error(), "/a")
+
+		content, err := os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(`{"a": 1, "b": 3}`, string(content))
+
+		findChild(root, "a").File.Content = "[1, 2]\n"
+		r.NoError(root.Persist())
+		r.NoError(root.Flush())
+
+		content, err = os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(`{"a": [1, 2], "b": 3}`, string(content))
+	})
+
+	t.Run("new files", func(t *testing.T) {
+		r := require.New(t)
+
+		storage, root := loadData(t, `{"a": 1, "tags": ["x"]}`)
+
+		// created files are empty, they are left out until they hold JSON
+		root.Directory.Content = append(root.Directory.Content, file.LemonDirectoryChild{
+			Type:   "file",
+			File:   &file.LemonFile{Type: "file", Name: "new"},
+			Parent: root,
+		})
+		r.NoError(root.Persist())
+		tags := findChild(root, "tags")
+		tags.Directory.Content = append(tags.Directory.Content, file.LemonDirectoryChild{
+			Type:   "file",
+			File:   &file.LemonFile{Type: "file", Name: "1"},
+			Parent: tags,
+		})
+		r.NoError(root.Persist())
+		findChild(root, "a").File.Content = "2\n"
+		r.NoError(root.Persist())
+		r.ErrorIs(root.Flush(), file.ErrInvalidData)
+
+		content, err := os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(`{"a": 2, "tags": ["x"]}`, string(content))
+
+		findChild(root, "new").File.Content = "true\n"
+		findChild(tags, "1").File.Content = `"y"`
+		r.NoError(root.Persist())
+		r.NoError(root.Flush())
+
+		content, err = os.ReadFile(storage.Path())
+		r.NoError(err)
+		r.JSONEq(`{"a": 2, "tags": ["x", "y"], "new": true}`, string(content))
+	})
+
+	t.Run("invalid changes", func(t *testing.T) {
+		r := require.New(t)
+
+		_, root := loadData(t, `{"tags": ["a"]}`)
+
+		tags := findChild(root, "tags")
+		tags.Directory.Content = append(tags.Directory.Content, file.LemonDirectoryChild{
+			Type:   "file",
+			File:   &file.LemonFile{Type: "file", Name: "x", Content: "1"},
+			Parent: tags,
+		})
+		r.True(errors.Is(root.Persist(), syscall.EINVAL))
+
+		tags.Directory.Content = tags.Directory.Content[:1]
+		root.Directory.Content = append(root.Directory.Content, file.LemonDirectoryChild{
+			Type:    "symlink",
+			Symlink: &file.LemonSymlink{Type: "symlink", Name: "l", Target: "tags"},
+			Parent:  root,
+		})
+		r.ErrorIs(root.Persist(), file.ErrInvalidData)
+	})
+}
@@ -0,0 +1,72 @@
+package file
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// the encodings of the content of a file in the JSON file, the content of a LemonFile in memory is always raw bytes
+const (
+	// EncodingUTF8 stores the content as a JSON string, it is the default so text files stay readable
+	EncodingUTF8 = "utf8"
+	// EncodingBase64 stores the content as base64, it is used for content which isn't valid UTF-8
+	EncodingBase64 = "base64"
+)
+
+// lemonFileJSON has the fields of LemonFile without its methods, so it is encoded by encoding/json
+type lemonFileJSON LemonFile
+
+// encodedFile is a LemonFile as stored in the JSON file
+type encodedFile struct {
+	lemonFileJSON
+
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// MarshalJSON stores the content as text if it is valid UTF-8, encoding/json would replace the invalid bytes otherwise
+func (f *LemonFile) MarshalJSON() ([]byte, error) {
+	encoded := encodedFile{lemonFileJSON: lemonFileJSON(*f)}
+
+	if !utf8.ValidString(f.Content) {
+		encoded.Encoding = EncodingBase64
+		encoded.Content = base64.StdEncoding.EncodeToString([]byte(f.Content))
+	}
+
+	return json.Marshal(&encoded)
+}
+
+func (f *LemonFile) UnmarshalJSON(data []byte) error {
+	encoded := encodedFile{}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	content, err := decodeContent(encoded.Content, encoded.Encoding)
+	if err != nil {
+		return fmt.Errorf("file %s: %w", encoded.Name, err)
+	}
+	encoded.Content = content
+
+	*f = LemonFile(encoded.lemonFileJSON)
+
+	return nil
+}
+
+// decodeContent returns the raw content of a file stored with encoding, an empty encoding is EncodingUTF8
+func decodeContent(content string, encoding string) (string, error) {
+	switch encoding {
+	case "", EncodingUTF8:
+		return content, nil
+	case EncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 content: %w", err)
+		}
+
+		return string(decoded), nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
@@ -0,0 +1,61 @@
+package file_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoding(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		r := require.New(t)
+
+		jsonContent, err := json.Marshal(&file.LemonFile{Type: "file", Name: "a", Content: "hello, 世界\n"})
+		r.NoError(err)
+		r.Contains(string(jsonContent), `"content":"hello, 世界\n"`)
+		r.NotContains(string(jsonContent), `"encoding"`)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		r := require.New(t)
+
+		binary := string([]byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe})
+		jsonContent, err := json.Marshal(&file.LemonFile{Type: "file", Name: "a", Content: binary})
+		r.NoError(err)
+		r.Contains(string(jsonContent), `"content":"H4sIAP/+"`)
+		r.Contains(string(jsonContent), `"encoding":"base64"`)
+
+		loaded := &file.LemonFile{}
+		r.NoError(json.Unmarshal(jsonContent, loaded))
+		r.Equal(binary, loaded.Content)
+	})
+
+	t.Run("explicit encoding", func(t *testing.T) {
+		r := require.New(t)
+
+		loaded := &file.LemonFile{}
+		r.NoError(json.Unmarshal([]byte(`{"type": "file", "name": "a", "content": "aGVsbG8=", "encoding": "base64"}`), loaded))
+		r.Equal("hello", loaded.Content)
+
+		r.NoError(json.Unmarshal([]byte(`{"type": "file", "name": "a", "content": "aGVsbG8=", "encoding": "utf8"}`), loaded))
+		r.Equal("aGVsbG8=", loaded.Content)
+
+		r.Error(json.Unmarshal([]byte(`{"type": "file", "name": "a", "content": "not base64", "encoding": "base64"}`), loaded))
+		r.Error(json.Unmarshal([]byte(`{"type": "file", "name": "a", "content": "", "encoding": "hex"}`), loaded))
+	})
+
+	t.Run("fsck", func(t *testing.T) {
+		r := require.New(t)
+
+		problems, _, err := file.Check([]byte(`{"type": "directory", "name": "root", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1, "content": [
+			{"type": "file", "name": "a", "content": "not base64", "encoding": "base64", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1},
+			{"type": "file", "name": "b", "content": "", "encoding": "hex", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1}
+		]}`), false)
+		r.NoError(err)
+		r.Equal(2, len(problems))
+		r.Contains(problems[0].Message, "invalid base64 content")
+		r.Contains(problems[1].Message, `unknown encoding "hex"`)
+	})
+}
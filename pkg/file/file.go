@@ -2,42 +2,125 @@ package file
 
 import (
 	"encoding/json"
-	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 type LemonFile struct {
+	LemonPermission
+
 	Type           string `json:"type"`
 	Name           string `json:"name"`
 	Content        string `json:"content"`
 	CreatedAt      uint64 `json:"created_at"`
 	LastAccessedAt uint64 `json:"last_accessed_at"`
 	LastModifiedAt uint64 `json:"last_modified_at"`
+
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+
+	// ID is shared by all hard links of the file, it is 0 if the file was never linked
+	ID uint64 `json:"id,omitempty"`
+	// Nlink is the number of directory entries referring to the file, it is 0 before ResolveHardLinks is called
+	Nlink uint32 `json:"-"`
+}
+
+// Links returns the number of hard links of the file
+func (f *LemonFile) Links() uint32 {
+	return max(f.Nlink, 1)
+}
+
+// Truncate cuts the content to size, or pads it with zero bytes if it is shorter than size
+func (f *LemonFile) Truncate(size uint64) {
+	f.resize(size)
+
+	now := uint64(time.Now().Unix())
+	f.LastModifiedAt = now
+	f.CreatedAt = now
+}
+
+func (f *LemonFile) resize(size uint64) {
+	if size <= uint64(len(f.Content)) {
+		f.Content = f.Content[:size]
+	} else {
+		f.Content += string(make([]byte, size-uint64(len(f.Content))))
+	}
+}
+
+// WriteAt splices data into the content at off, the gap between the end of the content and off is filled with zero bytes
+func (f *LemonFile) WriteAt(data []byte, off int64) {
+	end := off + int64(len(data))
+
+	buf := []byte(f.Content)
+	if end > int64(len(buf)) {
+		buf = append(buf, make([]byte, end-int64(len(buf)))...)
+	}
+
+	copy(buf[off:end], data)
+
+	f.Content = string(buf)
+}
+
+// LemonSymlink is a symbolic link, Target is stored as is and may be relative
+type LemonSymlink struct {
+	LemonPermission
+
+	Type           string `json:"type"`
+	Name           string `json:"name"`
+	Target         string `json:"target"`
+	CreatedAt      uint64 `json:"created_at"`
+	LastAccessedAt uint64 `json:"last_accessed_at"`
+	LastModifiedAt uint64 `json:"last_modified_at"`
 }
 
 type LemonDirectoryChild struct {
 	Type      string          `json:"type"`
 	File      *LemonFile      `json:"file"`
 	Directory *LemonDirectory `json:"directory"`
+	Symlink   *LemonSymlink   `json:"symlink"`
+
+	// LinkName is the name of this directory entry if the file has hard links,
+	// the shared File.Name belongs to one of the links only
+	LinkName string
+
+	Parent *LemonDirectoryChild
 
-	Parent     *LemonDirectoryChild
-	TargetFile string
+	// link is set on a hard link loaded without its file, which is stored with another link, until ResolveHardLinks
+	link bool
+
+	// the fields below are only set on the root of a tree, see SetStorage
+	storage  Storage
+	treeLock *sync.RWMutex
 }
 
 func (c *LemonDirectoryChild) IsFile() bool {
-	return c.File != nil && c.Directory == nil
+	return c.File != nil && c.Directory == nil && c.Symlink == nil
 }
 
 func (c *LemonDirectoryChild) IsDirectory() bool {
-	return c.File == nil && c.Directory != nil
+	return c.File == nil && c.Directory != nil && c.Symlink == nil
+}
+
+func (c *LemonDirectoryChild) IsSymlink() bool {
+	return c.File == nil && c.Directory == nil && c.Symlink != nil
 }
 
 func (c *LemonDirectoryChild) Rename(newName string) {
+	if c.LinkName != "" {
+		c.LinkName = newName
+		return
+	}
+
 	if c.IsFile() {
 		c.File.Name = newName
 		return
 	}
 
+	if c.IsSymlink() {
+		c.Symlink.Name = newName
+		return
+	}
+
 	c.Directory.Name = newName
 }
 
@@ -48,7 +131,14 @@ func (c *LemonDirectoryChild) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	if raw["type"] == "file" {
+	if raw["type"] == "file" && raw["link_id"] != nil {
+		l := &linkJSON{}
+		err = json.Unmarshal(data, l)
+		if err != nil {
+			return err
+		}
+		*c = LemonDirectoryChild{Type: "file", File: &LemonFile{Type: "file", Name: l.Name, ID: l.LinkID}, link: true}
+	} else if raw["type"] == "file" {
 		f := &LemonFile{}
 		err = json.Unmarshal(data, f)
 		if err != nil {
@@ -62,30 +152,90 @@ func (c *LemonDirectoryChild) UnmarshalJSON(data []byte) error {
 			return err
 		}
 		*c = LemonDirectoryChild{Type: "directory", Directory: d}
+	} else if raw["type"] == "symlink" {
+		l := &LemonSymlink{}
+		err = json.Unmarshal(data, l)
+		if err != nil {
+			return err
+		}
+		*c = LemonDirectoryChild{Type: "symlink", Symlink: l}
 	}
 
 	return nil
 }
 
 func (c *LemonDirectoryChild) MarshalJSON() ([]byte, error) {
+	return json.Marshal(treeJSON{node: c, written: map[*LemonFile]bool{}})
+}
+
+// treeJSON is a node with its children as stored in the JSON file. The file of hard links is stored with the first link
+// only, the other links refer to it by its ID, they are merged again by ResolveHardLinks.
+type treeJSON struct {
+	node *LemonDirectoryChild
+	// written are the files with hard links stored so far
+	written map[*LemonFile]bool
+}
+
+// linkJSON is a hard link whose file is stored with another link
+type linkJSON struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	LinkID uint64 `json:"link_id"`
+}
+
+// directoryJSON is a directory whose children are stored as treeJSON
+type directoryJSON struct {
+	*LemonDirectory
+
+	Content []treeJSON `json:"content"`
+}
+
+func (t treeJSON) MarshalJSON() ([]byte, error) {
+	c := t.node
+
+	if c.File != nil && c.File.ID != 0 {
+		if t.written[c.File] {
+			return json.Marshal(linkJSON{Type: "file", Name: c.Name(), LinkID: c.File.ID})
+		}
+		t.written[c.File] = true
+
+		link := *c.File
+		link.Name = c.Name()
+		return json.Marshal(&link)
+	}
+
 	if c.File != nil {
 		return json.Marshal(c.File)
 	}
 
 	if c.Directory != nil {
-		return json.Marshal(c.Directory)
+		dir := directoryJSON{LemonDirectory: c.Directory}
+		if c.Directory.Content != nil {
+			dir.Content = make([]treeJSON, len(c.Directory.Content))
+			for i := range c.Directory.Content {
+				dir.Content[i] = treeJSON{node: &c.Directory.Content[i], written: t.written}
+			}
+		}
+
+		return json.Marshal(dir)
+	}
+
+	if c.Symlink != nil {
+		return json.Marshal(c.Symlink)
 	}
 
 	return nil, nil
 }
 
-func (c *LemonDirectoryChild) WriteToFile() error {
-	jsonContent, err := json.Marshal(c.root())
-	if err != nil {
-		return err
+// Touch sets the modification and change time of the node to now, after its content or its entries have been changed
+func (c *LemonDirectoryChild) Touch() {
+	now := uint64(time.Now().Unix())
+	if c.IsFile() {
+		c.File.LastModifiedAt, c.File.CreatedAt = now, now
+		return
 	}
 
-	return os.WriteFile(c.TargetFile, jsonContent, 0644)
+	c.Directory.LastModifiedAt, c.Directory.CreatedAt = now, now
 }
 
 func (c *LemonDirectoryChild) root() *LemonDirectoryChild {
@@ -98,11 +248,17 @@ func (c *LemonDirectoryChild) root() *LemonDirectoryChild {
 }
 
 func (c *LemonDirectoryChild) Name() string {
+	if c.LinkName != "" {
+		return c.LinkName
+	}
+
 	switch c.Type {
 	case "file":
 		return c.File.Name
 	case "directory":
 		return c.Directory.Name
+	case "symlink":
+		return c.Symlink.Name
 	default:
 		return ""
 	}
@@ -116,24 +272,102 @@ func (c *LemonDirectoryChild) Path() string {
 	return filepath.Join(c.Parent.Path(), c.Name())
 }
 
-func (c *LemonDirectoryChild) ApplyParentAndTarget(parent *LemonDirectoryChild) {
+// ApplyParent sets the parent of the node and the parents of all nodes below it
+func (c *LemonDirectoryChild) ApplyParent(parent *LemonDirectoryChild) {
 	if parent != nil {
 		c.Parent = parent
-		c.TargetFile = parent.TargetFile
 	}
 
 	if c.Directory != nil {
 		for i := range c.Directory.Content {
-			c.Directory.Content[i].ApplyParentAndTarget(c)
+			c.Directory.Content[i].ApplyParent(c)
+		}
+	}
+}
+
+// ResolveHardLinks makes files with the same ID in the tree share one LemonFile and counts their links.
+// A link whose file is stored with another link gets the first file with its ID,
+// it is kept as an empty file if there is none.
+func (c *LemonDirectoryChild) ResolveHardLinks() {
+	files := map[uint64]*LemonFile{}
+	links := []*LemonDirectoryChild{}
+
+	share := func(node *LemonDirectoryChild, shared *LemonFile) {
+		node.LinkName = node.File.Name
+		node.File = shared
+		node.link = false
+		shared.Nlink++
+	}
+
+	var walk func(node *LemonDirectoryChild)
+	walk = func(node *LemonDirectoryChild) {
+		if node.IsFile() && node.File.ID != 0 {
+			if node.link {
+				links = append(links, node)
+				return
+			}
+
+			shared, ok := files[node.File.ID]
+			if !ok {
+				shared = node.File
+				shared.Nlink = 0
+				files[node.File.ID] = shared
+			}
+
+			share(node, shared)
+		}
+
+		if node.IsDirectory() {
+			for i := range node.Directory.Content {
+				walk(&node.Directory.Content[i])
+			}
+		}
+	}
+
+	walk(c)
+
+	for _, node := range links {
+		shared, ok := files[node.File.ID]
+		if !ok {
+			shared = node.File
+			files[node.File.ID] = shared
 		}
+
+		share(node, shared)
 	}
 }
 
+// NextFileID returns an unused file ID for a new hard link in the tree
+func (c *LemonDirectoryChild) NextFileID() uint64 {
+	maxID := uint64(0)
+
+	var walk func(node *LemonDirectoryChild)
+	walk = func(node *LemonDirectoryChild) {
+		if node.IsFile() {
+			maxID = max(maxID, node.File.ID)
+		}
+
+		if node.IsDirectory() {
+			for i := range node.Directory.Content {
+				walk(&node.Directory.Content[i])
+			}
+		}
+	}
+
+	walk(c.root())
+
+	return maxID + 1
+}
+
 type LemonDirectory struct {
+	LemonPermission
+
 	Type           string                `json:"type"`
 	Name           string                `json:"name"`
 	Content        []LemonDirectoryChild `json:"content"`
 	CreatedAt      uint64                `json:"created_at"`
 	LastAccessedAt uint64                `json:"last_accessed_at"`
 	LastModifiedAt uint64                `json:"last_modified_at"`
+
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
 }
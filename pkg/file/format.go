@@ -0,0 +1,274 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the document format of the file of a FileStorage.
+// Every format stores the same schema as the JSON file, the other formats are converted from and to JSON,
+// so the encoding of the content and hard links work the same way in all of them.
+type Format interface {
+	// Name is the name of the format, as accepted by ParseFormat
+	Name() string
+	Marshal(root *LemonDirectoryChild) ([]byte, error)
+	// Unmarshal decodes the tree in data into root
+	Unmarshal(data []byte, root *LemonDirectoryChild) error
+	// ToJSON converts a document of the format to JSON, so it can be checked by Check
+	ToJSON(data []byte) ([]byte, error)
+}
+
+var (
+	JSON Format = jsonFormat{}
+	YAML Format = yamlFormat{}
+	TOML Format = tomlFormat{}
+)
+
+// ParseFormat returns the format called name
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return JSON, nil
+	case "yaml", "yml":
+		return YAML, nil
+	case "toml":
+		return TOML, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, use json, yaml or toml", name)
+	}
+}
+
+// FormatOf returns the format of path by its extension, files with an unknown extension are JSON
+func FormatOf(path string) Format {
+	format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return JSON
+	}
+
+	return format
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string {
+	return "json"
+}
+
+func (jsonFormat) Marshal(root *LemonDirectoryChild) ([]byte, error) {
+	return json.Marshal(root)
+}
+
+func (jsonFormat) Unmarshal(data []byte, root *LemonDirectoryChild) error {
+	return json.Unmarshal(data, root)
+}
+
+func (jsonFormat) ToJSON(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// yamlFormat keeps the order of the fields of the JSON schema, so type and name come first in every node
+type yamlFormat struct{}
+
+func (yamlFormat) Name() string {
+	return "yaml"
+}
+
+func (yamlFormat) Marshal(root *LemonDirectoryChild) ([]byte, error) {
+	content, err := json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	node, err := yamlNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (f yamlFormat) Unmarshal(data []byte, root *LemonDirectoryChild) error {
+	content, err := f.ToJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, root)
+}
+
+func (yamlFormat) ToJSON(data []byte) ([]byte, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(document)
+}
+
+// yamlNode reads the next JSON value from decoder as a YAML node
+func yamlNode(decoder *json.Decoder) (*yaml.Node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if token == '{' {
+			node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+
+		for decoder.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+			}
+
+			child, err := yamlNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+
+		// the closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+	case string:
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: token}
+		if strings.Contains(token, "\n") {
+			node.Style = yaml.DoubleQuotedStyle
+			if yamlLiteral(token) {
+				node.Style = yaml.LiteralStyle
+			}
+		}
+
+		return node, nil
+	case json.Number:
+		if strings.ContainsAny(token.String(), ".eE") {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: token.String()}, nil
+		}
+
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: token.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(token)}, nil
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+}
+
+// yamlLiteral reports whether value is read back unchanged from a literal block, which keeps text readable.
+// yaml.v3 loses leading newlines, and leading tabs or carriage returns can't be written in literal blocks.
+func yamlLiteral(value string) bool {
+	if strings.HasPrefix(value, "\n") {
+		return false
+	}
+
+	content, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.LiteralStyle})
+	if err != nil {
+		return false
+	}
+
+	var decoded string
+	return yaml.Unmarshal(content, &decoded) == nil && decoded == value
+}
+
+// tomlFormat stores the children of a directory as an array of tables.
+// TOML has no null, so null fields are left out and decoded as their zero values.
+type tomlFormat struct{}
+
+func (tomlFormat) Name() string {
+	return "toml"
+}
+
+func (tomlFormat) Marshal(root *LemonDirectoryChild) ([]byte, error) {
+	content, err := json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(tomlValue(document)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (f tomlFormat) Unmarshal(data []byte, root *LemonDirectoryChild) error {
+	content, err := f.ToJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, root)
+}
+
+func (tomlFormat) ToJSON(data []byte) ([]byte, error) {
+	document := map[string]any{}
+	if _, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(document)
+}
+
+// tomlValue converts a JSON value decoded with UseNumber to the types of the TOML encoder
+func tomlValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if child == nil {
+				delete(value, key)
+				continue
+			}
+			value[key] = tomlValue(child)
+		}
+	case []any:
+		for i, child := range value {
+			value[i] = tomlValue(child)
+		}
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+		float, _ := value.Float64()
+		return float
+	}
+
+	return value
+}
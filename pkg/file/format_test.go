@@ -0,0 +1,142 @@
+package file_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/stretchr/testify/require"
+)
+
+func formatTestTree() *file.LemonDirectoryChild {
+	shared := &file.LemonFile{
+		LemonPermission: file.NewLemonPermission(0o600, 1000, 1000),
+		Type:            "file",
+		Name:            "a",
+		Content:         "line 1\nline 2\n",
+		CreatedAt:       1700000000,
+		LastAccessedAt:  1700000001,
+		LastModifiedAt:  1700000002,
+		Xattrs:          map[string][]byte{"user.tag": []byte("lemon")},
+		ID:              1,
+	}
+	link := *shared
+	link.Name = "b"
+
+	root := &file.LemonDirectoryChild{
+		Type: "directory",
+		Directory: &file.LemonDirectory{
+			LemonPermission: file.NewLemonPermission(file.DefaultDirectoryMode, 0, 0),
+			Type:            "directory",
+			CreatedAt:       1,
+			LastAccessedAt:  2,
+			LastModifiedAt:  3,
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: shared},
+				{Type: "file", File: &link},
+				{Type: "file", File: &file.LemonFile{Type: "file", Name: "yes", Content: "123", CreatedAt: 1, LastAccessedAt: 1, LastModifiedAt: 1}},
+				{Type: "file", File: &file.LemonFile{Type: "file", Name: "binary", Content: string([]byte{0x1f, 0x8b, 0xff}), CreatedAt: 1, LastAccessedAt: 1, LastModifiedAt: 1}},
+				{Type: "symlink", Symlink: &file.LemonSymlink{Type: "symlink", Name: "c", Target: "../a", CreatedAt: 1, LastAccessedAt: 1, LastModifiedAt: 1}},
+				{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "empty", Content: []file.LemonDirectoryChild{}, CreatedAt: 1, LastAccessedAt: 1, LastModifiedAt: 1}},
+			},
+		},
+	}
+	root.ApplyParent(nil)
+	root.ResolveHardLinks()
+
+	return root
+}
+
+func TestFormat(t *testing.T) {
+	for _, format := range []file.Format{file.JSON, file.YAML, file.TOML} {
+		t.Run(format.Name(), func(t *testing.T) {
+			r := require.New(t)
+
+			path := filepath.Join(t.TempDir(), "lemonfs."+format.Name())
+			r.Equal(format, file.FormatOf(path))
+
+			root := formatTestTree()
+			storage := file.NewFileStorage(path, format)
+			r.NoError(storage.Persist(root, nil))
+
+			loaded, err := file.NewFileStorage(path, format).Load()
+			r.NoError(err)
+
+			expected, err := json.Marshal(root)
+			r.NoError(err)
+			actual, err := json.Marshal(loaded)
+			r.NoError(err)
+			r.JSONEq(string(expected), string(actual))
+
+			// hard links are shared again
+			r.Same(loaded.Directory.Content[0].File, loaded.Directory.Content[1].File)
+			r.Equal(uint32(2), loaded.Directory.Content[0].File.Nlink)
+
+			content, err := os.ReadFile(path)
+			r.NoError(err)
+			// the content of the hard links is stored once
+			r.Equal(1, strings.Count(string(content), "line 2"))
+
+			// the usage is the size of the file in its format
+			used, nodes, err := loaded.Usage()
+			r.NoError(err)
+			r.Equal(uint64(len(content)), used)
+			r.Equal(uint64(7), nodes)
+
+			jsonContent, err := format.ToJSON(content)
+			r.NoError(err)
+			problems, _, err := file.Check(jsonContent, false)
+			r.NoError(err)
+			r.Empty(problems)
+		})
+	}
+
+	t.Run("yaml is readable", func(t *testing.T) {
+		r := require.New(t)
+
+		content, err := file.YAML.Marshal(formatTestTree())
+		r.NoError(err)
+		r.Contains(string(content), "type: directory\n")
+		r.Contains(string(content), "content: |\n")
+		// strings which look like numbers are quoted
+		r.Contains(string(content), `content: "123"`)
+	})
+
+	t.Run("yaml newlines", func(t *testing.T) {
+		for _, content := range []string{"\n", "\n\n", "\nx", "\n\nx", "x\n\n", "x\n\ny\n", "  x\ny", "x \ny", "\tx\ny", "x\r\ny", " \n"} {
+			r := require.New(t)
+
+			root := &file.LemonDirectoryChild{
+				Type: "directory",
+				Directory: &file.LemonDirectory{
+					Type: "directory",
+					Content: []file.LemonDirectoryChild{
+						{Type: "file", File: &file.LemonFile{Type: "file", Name: "a", Content: content}},
+					},
+				},
+			}
+
+			data, err := file.YAML.Marshal(root)
+			r.NoError(err)
+
+			loaded := &file.LemonDirectoryChild{}
+			r.NoError(file.YAML.Unmarshal(data, loaded))
+			r.Equal(content, loaded.Directory.Content[0].File.Content, "%q stored as\n%s", content, data)
+		}
+	})
+
+	t.Run("format of path", func(t *testing.T) {
+		r := require.New(t)
+
+		r.Equal(file.YAML, file.FormatOf("config.yml"))
+		r.Equal(file.TOML, file.FormatOf("/a/b/config.TOML"))
+		r.Equal(file.JSON, file.FormatOf("lemonfs.json"))
+		r.Equal(file.JSON, file.FormatOf("lemonfs"))
+
+		_, err := file.ParseFormat("xml")
+		r.Error(err)
+	})
+}
@@ -0,0 +1,375 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LostAndFound is the directory in the root where Check puts the nodes it can't keep in place
+const LostAndFound = "lost+found"
+
+// Problem is a structural problem of a tree found by Check
+type Problem struct {
+	// Path is the JSON path of the node, like $.content[1].content[0]
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// Check parses a tree serialized by FileStorage and returns all its structural problems.
+// If repair is set the problems are fixed and the repaired tree is returned:
+// duplicate names get a numeric suffix, missing timestamps are set to now, and nodes which can't be kept in place
+// are moved to the lost+found directory, as files holding their JSON if they are invalid.
+// Files written before hard links referred to their file by ID store a copy of the file per link,
+// a copy which differs from the first one is reported and replaced by a link to it, as only the first one is loaded.
+// An error is returned if data is not JSON at all.
+func Check(data []byte, repair bool) ([]Problem, *LemonDirectoryChild, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// keep IDs, uids and timestamps exact
+	decoder.UseNumber()
+
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, err
+	}
+
+	c := &checker{now: uint64(time.Now().Unix()), files: map[string]storedNode{}}
+
+	root, ok := raw.(map[string]any)
+	if !ok || root["type"] != "directory" || c.invalid(root) != "" {
+		c.report("$", "the root is not a valid directory")
+		c.lose("$", raw)
+
+		root = map[string]any{"type": "directory", "name": "", "content": []any{}}
+	}
+
+	c.checkNode(root, "$")
+	c.checkLinks()
+
+	if !repair {
+		return c.problems, nil, nil
+	}
+
+	if len(c.lost) > 0 {
+		root["content"] = c.addLostAndFound(root["content"].([]any))
+	}
+
+	repaired, err := json.Marshal(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree := &LemonDirectoryChild{}
+	if err := json.Unmarshal(repaired, tree); err != nil {
+		return nil, nil, err
+	}
+	tree.ApplyParent(nil)
+	tree.ResolveHardLinks()
+
+	return c.problems, tree, nil
+}
+
+type checker struct {
+	problems []Problem
+	// lost are the nodes to be moved to lost+found, keyed by their JSON path
+	lost []lostNode
+	now  uint64
+
+	// files are the first stored files with hard links by their ID, links are the links referring to them
+	files map[string]storedNode
+	links []storedNode
+}
+
+type lostNode struct {
+	path string
+	node any
+}
+
+type storedNode struct {
+	path string
+	node map[string]any
+}
+
+func (c *checker) report(path string, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// lose moves node to lost+found when repairing
+func (c *checker) lose(path string, node any) {
+	c.lost = append(c.lost, lostNode{path: path, node: node})
+}
+
+// invalid returns why node can't be loaded as a file, directory or symlink, or an empty string if it can
+func (c *checker) invalid(node map[string]any) string {
+	switch node["type"] {
+	case "file":
+		if node["link_id"] != nil {
+			linkID, ok := node["link_id"].(json.Number)
+			if id, err := strconv.ParseUint(linkID.String(), 10, 64); !ok || err != nil || id == 0 {
+				return "a hard link with an invalid link_id"
+			}
+			return ""
+		}
+		if _, ok := node["content"].([]any); ok {
+			return "a file with the content of a directory"
+		}
+		if content, ok := node["content"]; ok && content != nil {
+			if _, ok := content.(string); !ok {
+				return "a file with invalid content"
+			}
+		}
+		encoding, ok := node["encoding"].(string)
+		if !ok && node["encoding"] != nil {
+			return "a file with invalid encoding"
+		}
+		content, _ := node["content"].(string)
+		if _, err := decodeContent(content, encoding); err != nil {
+			return "a file with " + err.Error()
+		}
+	case "directory":
+		if _, ok := node["content"].(string); ok {
+			return "a directory with the content of a file"
+		}
+		if content, ok := node["content"]; ok && content != nil {
+			if _, ok := content.([]any); !ok {
+				return "a directory with invalid content"
+			}
+		}
+	case "symlink":
+		if _, ok := node["target"].(string); !ok {
+			return "a symlink without target"
+		}
+	case nil:
+		return "no type"
+	default:
+		return fmt.Sprintf("unknown type %v", node["type"])
+	}
+
+	return ""
+}
+
+// checkDirectory checks the children of a valid directory node and keeps the ones to be kept in place
+func (c *checker) checkDirectory(dir map[string]any, path string) {
+	children, _ := dir["content"].([]any)
+	if dir["content"] == nil {
+		c.report(path, "a directory without content")
+	}
+
+	kept := []any{}
+	names := map[string]bool{}
+
+	for index, child := range children {
+		childPath := fmt.Sprintf("%s.content[%d]", path, index)
+
+		node, ok := child.(map[string]any)
+		if !ok {
+			c.report(childPath, "not a JSON object")
+			c.lose(childPath, child)
+			continue
+		}
+
+		if reason := c.invalid(node); reason != "" {
+			c.report(childPath, "%s", reason)
+			c.lose(childPath, node)
+			continue
+		}
+
+		name, _ := node["name"].(string)
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			if name == "" {
+				c.report(childPath, "an entry without name")
+			} else {
+				c.report(childPath, "an invalid name %q", name)
+			}
+
+			c.checkNode(node, childPath)
+			c.lose(childPath, node)
+			continue
+		}
+
+		if names[name] {
+			newName := uniqueName(name, names)
+			c.report(childPath, "a duplicate name %q, renamed to %q", name, newName)
+			node["name"] = newName
+			name = newName
+		}
+		names[name] = true
+
+		c.checkNode(node, childPath)
+		kept = append(kept, node)
+	}
+
+	dir["content"] = kept
+}
+
+// checkNode checks a valid node and its children
+func (c *checker) checkNode(node map[string]any, path string) {
+	// a hard link only has a name, the file is stored with another link
+	if node["type"] == "file" && node["link_id"] != nil {
+		c.links = append(c.links, storedNode{path: path, node: node})
+		return
+	}
+
+	c.checkTimestamps(node, path)
+
+	if node["type"] == "file" && node["id"] != nil {
+		c.checkCopy(node, path)
+	}
+
+	if node["type"] == "directory" {
+		c.checkDirectory(node, path)
+	}
+}
+
+// checkCopy compares a file with hard links with the first stored file with its ID
+func (c *checker) checkCopy(node map[string]any, path string) {
+	id := fmt.Sprint(node["id"])
+
+	first, ok := c.files[id]
+	if !ok {
+		c.files[id] = storedNode{path: path, node: node}
+		return
+	}
+
+	if sameFile(first.node, node) {
+		return
+	}
+
+	c.report(path, "a hard link which differs from the file at %s, replaced by a link to it", first.path)
+
+	name := node["name"]
+	clear(node)
+	node["type"], node["name"], node["link_id"] = "file", name, first.node["id"]
+}
+
+// sameFile reports whether two stored files only differ by their name
+func sameFile(a, b map[string]any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		other, ok := b[key]
+		if key != "name" && (!ok || !reflect.DeepEqual(value, other)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkLinks checks that the file of every hard link is stored, a link to a missing file is kept as an empty file
+func (c *checker) checkLinks() {
+	for _, link := range c.links {
+		id := fmt.Sprint(link.node["link_id"])
+		if _, ok := c.files[id]; ok {
+			continue
+		}
+
+		c.report(link.path, "a hard link to the missing file %s, kept as an empty file", id)
+
+		link.node["id"] = link.node["link_id"]
+		delete(link.node, "link_id")
+		link.node["content"] = ""
+		for _, field := range []string{"created_at", "last_accessed_at", "last_modified_at"} {
+			link.node[field] = json.Number(strconv.FormatUint(c.now, 10))
+		}
+
+		// the other links to the file refer to this one
+		c.files[id] = link
+	}
+}
+
+func (c *checker) checkTimestamps(node map[string]any, path string) {
+	for _, field := range []string{"created_at", "last_accessed_at", "last_modified_at"} {
+		value, ok := node[field].(json.Number)
+		if ok {
+			if timestamp, err := strconv.ParseUint(value.String(), 10, 64); err == nil && timestamp != 0 {
+				continue
+			}
+		}
+
+		c.report(path, "a missing %s", field)
+		node[field] = json.Number(strconv.FormatUint(c.now, 10))
+	}
+}
+
+// addLostAndFound adds the lost nodes to the lost+found directory in the children of the root
+func (c *checker) addLostAndFound(children []any) []any {
+	var lostAndFound map[string]any
+	names := map[string]bool{}
+
+	for _, child := range children {
+		node := child.(map[string]any)
+		names[node["name"].(string)] = true
+
+		if node["name"] == LostAndFound && node["type"] == "directory" {
+			lostAndFound = node
+		}
+	}
+
+	if lostAndFound == nil {
+		lostAndFound = map[string]any{
+			"type":             "directory",
+			"name":             uniqueName(LostAndFound, names),
+			"content":          []any{},
+			"created_at":       c.now,
+			"last_accessed_at": c.now,
+			"last_modified_at": c.now,
+		}
+		children = append(children, lostAndFound)
+	}
+
+	lostNames := map[string]bool{}
+	content := lostAndFound["content"].([]any)
+	for _, child := range content {
+		lostNames[child.(map[string]any)["name"].(string)] = true
+	}
+
+	for _, lost := range c.lost {
+		name := uniqueName(lost.path, lostNames)
+		lostNames[name] = true
+
+		// keep a valid node as is, only its name is wrong
+		if node, ok := lost.node.(map[string]any); ok && c.invalid(node) == "" {
+			node["name"] = name
+			content = append(content, node)
+			continue
+		}
+
+		raw, _ := json.Marshal(lost.node)
+		content = append(content, map[string]any{
+			"type":             "file",
+			"name":             name,
+			"content":          string(raw),
+			"created_at":       c.now,
+			"last_accessed_at": c.now,
+			"last_modified_at": c.now,
+		})
+	}
+	lostAndFound["content"] = content
+
+	return children
+}
+
+// uniqueName returns name, or name with the smallest numeric suffix which is not in names
+func uniqueName(name string, names map[string]bool) string {
+	if !names[name] {
+		return name
+	}
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d", name, i)
+		if !names[candidate] {
+			return candidate
+		}
+	}
+}
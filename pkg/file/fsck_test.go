@@ -0,0 +1,142 @@
+package file_test
+
+import (
+	"testing"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	broken := []byte(`{"type": "directory", "name": "root", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1, "content": [
+		{"type": "file", "name": "a", "content": "a", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1},
+		{"type": "file", "name": "a", "content": "b", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1},
+		{"type": "fifo", "name": "c"},
+		{"type": "file", "name": "d", "content": []},
+		{"type": "directory", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1, "content": [
+			{"type": "file", "name": "e", "content": "e"}
+		]}
+	]}`)
+
+	t.Run("check", func(t *testing.T) {
+		r := require.New(t)
+
+		problems, repaired, err := file.Check(broken, false)
+		r.NoError(err)
+		r.Nil(repaired)
+
+		paths := []string{}
+		for _, problem := range problems {
+			paths = append(paths, problem.Path)
+		}
+
+		r.Equal([]string{
+			"$.content[1]",
+			"$.content[2]",
+			"$.content[3]",
+			"$.content[4]",
+			"$.content[4].content[0]",
+			"$.content[4].content[0]",
+			"$.content[4].content[0]",
+		}, paths)
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		r := require.New(t)
+
+		problems, repaired, err := file.Check(broken, true)
+		r.NoError(err)
+		r.Equal(7, len(problems))
+
+		names := []string{}
+		for _, child := range repaired.Directory.Content {
+			names = append(names, child.Name())
+		}
+		r.Equal([]string{"a", "a.1", file.LostAndFound}, names)
+		r.Equal("b", repaired.Directory.Content[1].File.Content)
+
+		lostAndFound := repaired.Directory.Content[2].Directory
+		r.Equal(3, len(lostAndFound.Content))
+
+		// invalid nodes are kept as JSON
+		r.True(lostAndFound.Content[0].IsFile())
+		r.Contains(lostAndFound.Content[0].File.Content, `"fifo"`)
+		r.True(lostAndFound.Content[1].IsFile())
+
+		// the nameless directory is kept with its children
+		r.True(lostAndFound.Content[2].IsDirectory())
+		r.Equal("e", lostAndFound.Content[2].Directory.Content[0].File.Content)
+		r.NotZero(lostAndFound.Content[2].Directory.Content[0].File.CreatedAt)
+
+		// the repaired tree is clean
+		jsonContent, err := repaired.MarshalJSON()
+		r.NoError(err)
+
+		problems, _, err = file.Check(jsonContent, false)
+		r.NoError(err)
+		r.Empty(problems)
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		r := require.New(t)
+
+		problems, repaired, err := file.Check([]byte(`{"name": "package", "version": "1.0.0"}`), true)
+		r.NoError(err)
+		r.Equal("$", problems[0].Path)
+		r.True(repaired.IsDirectory())
+		r.Equal(file.LostAndFound, repaired.Directory.Content[0].Name())
+	})
+
+	t.Run("hard links", func(t *testing.T) {
+		r := require.New(t)
+
+		// a and b are copies written before links referred to their file, c differs from them, d refers to a missing file
+		document := []byte(`{"type": "directory", "name": "root", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1, "content": [
+			{"type": "file", "name": "a", "content": "x", "id": 1, "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1},
+			{"type": "file", "name": "b", "content": "x", "id": 1, "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1},
+			{"type": "file", "name": "c", "content": "y", "id": 1, "created_at": 1, "last_accessed_at": 1, "last_modified_at": 2},
+			{"type": "file", "name": "d", "link_id": 2},
+			{"type": "file", "name": "e", "link_id": 2},
+			{"type": "file", "name": "f", "link_id": 1}
+		]}`)
+
+		problems, repaired, err := file.Check(document, true)
+		r.NoError(err)
+
+		paths := []string{}
+		for _, problem := range problems {
+			paths = append(paths, problem.Path)
+		}
+		r.Equal([]string{"$.content[2]", "$.content[3]"}, paths)
+
+		content := repaired.Directory.Content
+		for _, index := range []int{1, 2, 5} {
+			r.Same(content[0].File, content[index].File, content[index].Name())
+		}
+		r.Equal("x", content[0].File.Content)
+		r.Equal(uint32(4), content[0].File.Nlink)
+
+		r.Same(content[3].File, content[4].File)
+		r.Equal("", content[3].File.Content)
+		r.NotZero(content[3].File.CreatedAt)
+
+		problems, _, err = file.Check([]byte(`{"type": "directory", "name": "root", "created_at": 1, "last_accessed_at": 1, "last_modified_at": 1, "content": [
+			{"type": "file", "name": "a", "link_id": "1"}
+		]}`), false)
+		r.NoError(err)
+		r.Equal([]file.Problem{{Path: "$.content[0]", Message: "a hard link with an invalid link_id"}}, problems)
+
+		// the repaired tree is clean
+		jsonContent, err := repaired.MarshalJSON()
+		r.NoError(err)
+
+		problems, _, err = file.Check(jsonContent, false)
+		r.NoError(err)
+		r.Empty(problems)
+	})
+
+	t.Run("not JSON", func(t *testing.T) {
+		_, _, err := file.Check([]byte(`{`), false)
+		require.Error(t, err)
+	})
+}
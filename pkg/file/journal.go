@@ -0,0 +1,539 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/samber/lo"
+)
+
+// opCheckpoint is the first record of a journal, it identifies the version of the target file the journal applies to
+const opCheckpoint = "checkpoint"
+
+// the operations of a Change
+const (
+	OpCreate  = "create"
+	OpMkdir   = "mkdir"
+	OpWrite   = "write"
+	OpRename  = "rename"
+	OpSetattr = "setattr"
+	OpDelete  = "delete"
+)
+
+// Change is a mutation of the tree, it is passed to Storage.Persist and recorded in the journal.
+// Nodes are identified by their path, the changes must be recorded in the order they are made.
+type Change struct {
+	Op      string `json:"op"`
+	Path    string `json:"path,omitempty"`
+	NewPath string `json:"new_path,omitempty"`
+
+	// Offset and Data are the range of a write, Time is the modification time it sets
+	Offset int64  `json:"offset,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+	Time   uint64 `json:"time,omitempty"`
+
+	// Node holds the attributes of a created or changed node, without the file content and the directory children.
+	// Size is the size of the changed file.
+	Node *LemonDirectoryChild `json:"node,omitempty"`
+	Size uint64               `json:"size,omitempty"`
+
+	// Checksum is the SHA-256 of the target file, only set in the checkpoint record
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// CreateChange records that node has been added to its parent
+func CreateChange(node *LemonDirectoryChild) *Change {
+	op := OpCreate
+	if node.IsDirectory() {
+		op = OpMkdir
+	}
+
+	return &Change{Op: op, Path: node.Path(), Node: node.attributes()}
+}
+
+// WriteChange records that data has been written to the content of node at off, with the modification time of node
+func WriteChange(node *LemonDirectoryChild, off int64, data []byte) *Change {
+	return &Change{Op: OpWrite, Path: node.Path(), Offset: off, Data: data, Time: node.File.LastModifiedAt}
+}
+
+// SetattrChange records the attributes of node, including its size, xattrs and file ID
+func SetattrChange(node *LemonDirectoryChild) *Change {
+	change := &Change{Op: OpSetattr, Path: node.Path(), Node: node.attributes()}
+	if node.IsFile() {
+		change.Size = uint64(len(node.File.Content))
+	}
+
+	return change
+}
+
+// RenameChange records that the node at oldPath has been moved to newPath, replacing the node at newPath
+func RenameChange(oldPath string, newPath string) *Change {
+	return &Change{Op: OpRename, Path: oldPath, NewPath: newPath}
+}
+
+// DeleteChange records that the node at path has been removed
+func DeleteChange(path string) *Change {
+	return &Change{Op: OpDelete, Path: path}
+}
+
+// attributes returns a copy of the node without the file content and the directory children
+func (c *LemonDirectoryChild) attributes() *LemonDirectoryChild {
+	node := &LemonDirectoryChild{Type: c.Type, LinkName: c.LinkName}
+
+	if c.File != nil {
+		f := *c.File
+		f.Content = ""
+		node.File = &f
+	}
+
+	if c.Directory != nil {
+		d := *c.Directory
+		d.Content = nil
+		node.Directory = &d
+	}
+
+	if c.Symlink != nil {
+		l := *c.Symlink
+		node.Symlink = &l
+	}
+
+	return node
+}
+
+// Journal is an append-only log of the changes made since the target file was last written.
+// Every change is synced to the disk before the operation returns, so the target file only needs
+// to be written at checkpoints, when the write-back cache is flushed.
+type Journal struct {
+	lock sync.Mutex
+	file *os.File
+	// size is the size of the journal without a partially appended record
+	size int64
+	// err is set if the journal couldn't be reset at a checkpoint, records appended after it would be lost
+	err error
+}
+
+// JournalPath returns the path of the journal of the target file
+func JournalPath(targetFile string) string {
+	return targetFile + ".journal"
+}
+
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{file: f}, nil
+}
+
+// Replay applies the changes in the journal to the tree of root, which must be loaded from base, the content of the
+// target file, with its hard links resolved. The journal is discarded if it was recorded for another version of the
+// target file, its changes have already been checkpointed. It returns the number of replayed changes.
+func (j *Journal) Replay(root *LemonDirectoryChild, base []byte) (int, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	changes, err := j.read()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(changes) == 0 || changes[0].Op != opCheckpoint || changes[0].Checksum != checksum(base) {
+		return 0, j.reset(base)
+	}
+
+	for _, change := range changes[1:] {
+		if err := root.apply(change); err != nil {
+			log.Printf("Failed to replay %s of %s: %v", change.Op, change.Path, err)
+		}
+	}
+
+	// entries moved by the changes may point to stale parents
+	root.ApplyParent(nil)
+
+	return len(changes) - 1, nil
+}
+
+// read returns the records in the journal, a partially appended record at the end is dropped
+func (j *Journal) read() ([]*Change, error) {
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	changes, size, err := parseJournal(j.file)
+	if err != nil {
+		return nil, err
+	}
+
+	// records appended later must start on a new line
+	if err := j.file.Truncate(size); err != nil {
+		return nil, err
+	}
+	j.size = size
+
+	return changes, nil
+}
+
+// parseJournal returns the complete records read from r and their size
+func parseJournal(r io.Reader) ([]*Change, int64, error) {
+	changes := []*Change{}
+	size := int64(0)
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		change := &Change{}
+		if err := json.Unmarshal(line, change); err != nil {
+			break
+		}
+
+		changes = append(changes, change)
+		size += int64(len(line))
+	}
+
+	return changes, size, nil
+}
+
+// PendingChanges returns the number of changes in the journal of the target file which haven't been checkpointed yet,
+// they are lost if the target file is written without replaying them
+func PendingChanges(targetFile string) (int, error) {
+	f, err := os.Open(JournalPath(targetFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	base, err := os.ReadFile(targetFile)
+	if err != nil {
+		return 0, err
+	}
+
+	changes, _, err := parseJournal(f)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(changes) == 0 || changes[0].Op != opCheckpoint || changes[0].Checksum != checksum(base) {
+		return 0, nil
+	}
+
+	return len(changes) - 1, nil
+}
+
+// append writes the changes to the journal and syncs it
+func (j *Journal) append(changes []*Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.err != nil {
+		return j.err
+	}
+
+	buf := bytes.Buffer{}
+	for _, change := range changes {
+		line, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	_, err := j.file.Write(buf.Bytes())
+	if err == nil {
+		err = j.file.Sync()
+	}
+
+	if err != nil {
+		// drop the partial record, the change is rolled back
+		if truncateErr := j.file.Truncate(j.size); truncateErr != nil {
+			j.err = truncateErr
+		}
+
+		return err
+	}
+
+	j.size += int64(buf.Len())
+
+	return nil
+}
+
+// Reset empties the journal after base has been written to the target file
+func (j *Journal) Reset(base []byte) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.err = j.reset(base)
+
+	return j.err
+}
+
+func (j *Journal) reset(base []byte) error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	j.size = 0
+
+	line, err := json.Marshal(&Change{Op: opCheckpoint, Checksum: checksum(base)})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := j.file.Write(line); err != nil {
+		return err
+	}
+	j.size = int64(len(line))
+
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// apply makes a change recorded in the journal to the tree of c
+func (c *LemonDirectoryChild) apply(change *Change) error {
+	switch change.Op {
+	case OpCreate, OpMkdir:
+		return c.applyCreate(change)
+	case OpWrite:
+		node, err := c.lookup(change.Path)
+		if err != nil {
+			return err
+		}
+		if !node.IsFile() {
+			return syscall.EISDIR
+		}
+
+		node.File.WriteAt(change.Data, change.Offset)
+		if change.Time != 0 {
+			node.File.LastModifiedAt, node.File.CreatedAt = change.Time, change.Time
+		}
+
+		return nil
+	case OpSetattr:
+		node, err := c.lookup(change.Path)
+		if err != nil {
+			return err
+		}
+
+		return node.setAttributes(change.Node, change.Size)
+	case OpRename:
+		return c.applyRename(change)
+	case OpDelete:
+		parent, err := c.lookup(filepath.Dir(change.Path))
+		if err != nil {
+			return err
+		}
+		if !parent.IsDirectory() {
+			return syscall.ENOTDIR
+		}
+
+		parent.removeEntry(filepath.Base(change.Path))
+
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", change.Op)
+	}
+}
+
+func (c *LemonDirectoryChild) applyCreate(change *Change) error {
+	if change.Node == nil {
+		return fmt.Errorf("no node to create")
+	}
+
+	parent, err := c.lookup(filepath.Dir(change.Path))
+	if err != nil {
+		return err
+	}
+	if !parent.IsDirectory() {
+		return syscall.ENOTDIR
+	}
+
+	name := filepath.Base(change.Path)
+	node := *change.Node
+
+	if node.IsDirectory() && node.Directory.Content == nil {
+		node.Directory.Content = []LemonDirectoryChild{}
+	}
+
+	// a hard link shares the file with the same ID
+	if node.IsFile() && node.File.ID != 0 {
+		if shared := c.findFile(node.File.ID); shared != nil {
+			shared.Nlink = shared.Links() + 1
+			node.File = shared
+			node.LinkName = name
+		}
+	}
+
+	parent.removeEntry(name)
+	node.Parent = parent
+	parent.Directory.Content = append(parent.Directory.Content, node)
+
+	return nil
+}
+
+func (c *LemonDirectoryChild) applyRename(change *Change) error {
+	source, err := c.lookup(change.Path)
+	if err != nil {
+		return err
+	}
+
+	oldParent, err := c.lookup(filepath.Dir(change.Path))
+	if err != nil {
+		return err
+	}
+
+	newParent, err := c.lookup(filepath.Dir(change.NewPath))
+	if err != nil {
+		return err
+	}
+	if !newParent.IsDirectory() {
+		return syscall.ENOTDIR
+	}
+
+	oldName, newName := filepath.Base(change.Path), filepath.Base(change.NewPath)
+	entry := *source
+
+	oldParent.Directory.Content = lo.Filter(oldParent.Directory.Content, func(child LemonDirectoryChild, _ int) bool {
+		return child.Name() != oldName
+	})
+	newParent.removeEntry(newName)
+
+	entry.Rename(newName)
+	entry.Parent = newParent
+	newParent.Directory.Content = append(newParent.Directory.Content, entry)
+
+	return nil
+}
+
+// lookup returns the entry at path in the tree of c
+func (c *LemonDirectoryChild) lookup(path string) (*LemonDirectoryChild, error) {
+	node := c.root()
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+
+		if !node.IsDirectory() {
+			return nil, syscall.ENOTDIR
+		}
+
+		index := slices.IndexFunc(node.Directory.Content, func(child LemonDirectoryChild) bool {
+			return child.Name() == name
+		})
+		if index < 0 {
+			return nil, syscall.ENOENT
+		}
+
+		node = &node.Directory.Content[index]
+	}
+
+	return node, nil
+}
+
+// removeEntry removes the child with name from the directory, a removed hard link is no longer counted
+func (c *LemonDirectoryChild) removeEntry(name string) {
+	c.Directory.Content = lo.Filter(c.Directory.Content, func(child LemonDirectoryChild, _ int) bool {
+		if child.Name() != name {
+			return true
+		}
+
+		if child.IsFile() && child.File.Nlink > 1 {
+			child.File.Nlink--
+		}
+
+		return false
+	})
+}
+
+// findFile returns the file with id in the tree of c
+func (c *LemonDirectoryChild) findFile(id uint64) *LemonFile {
+	var found *LemonFile
+
+	var walk func(node *LemonDirectoryChild)
+	walk = func(node *LemonDirectoryChild) {
+		if found != nil {
+			return
+		}
+
+		if node.IsFile() && node.File.ID == id {
+			found = node.File
+			return
+		}
+
+		if node.IsDirectory() {
+			for i := range node.Directory.Content {
+				walk(&node.Directory.Content[i])
+			}
+		}
+	}
+
+	walk(c.root())
+
+	return found
+}
+
+// setAttributes replaces the attributes of c with the ones of node, a file is resized to size
+func (c *LemonDirectoryChild) setAttributes(node *LemonDirectoryChild, size uint64) error {
+	switch {
+	case node == nil:
+		return fmt.Errorf("no attributes to set")
+	case c.IsFile() && node.IsFile():
+		name, content, nlink := c.File.Name, c.File.Content, c.File.Nlink
+		*c.File = *node.File
+		c.File.Content, c.File.Nlink = content, nlink
+
+		// the name of a hard link is stored in the entry
+		if c.LinkName != "" {
+			c.File.Name = name
+		}
+
+		c.File.resize(size)
+	case c.IsDirectory() && node.IsDirectory():
+		content := c.Directory.Content
+		*c.Directory = *node.Directory
+		c.Directory.Content = content
+	case c.IsSymlink() && node.IsSymlink():
+		*c.Symlink = *node.Symlink
+	default:
+		return syscall.EINVAL
+	}
+
+	return nil
+}
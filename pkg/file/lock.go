@@ -0,0 +1,55 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ErrLocked is returned by Lock if the target file is already mounted by another process
+var ErrLocked = errors.New("the target file is locked")
+
+// LockPath returns the path of the lock file of the target file.
+// The target file itself can't be locked as it is replaced on every write.
+func LockPath(targetFile string) string {
+	return targetFile + ".lock"
+}
+
+// Lock takes an exclusive advisory lock of the target file, so only one process can write it.
+// The lock is released when the returned file is closed or the process exits.
+func Lock(targetFile string) (*os.File, error) {
+	lockFile, err := os.OpenFile(LockPath(targetFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer lockFile.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			// the holder writes its pid into the lock file
+			pid, _ := os.ReadFile(lockFile.Name())
+			if len(strings.TrimSpace(string(pid))) == 0 {
+				return nil, fmt.Errorf("%w by another process", ErrLocked)
+			}
+
+			return nil, fmt.Errorf("%w by process %s", ErrLocked, strings.TrimSpace(string(pid)))
+		}
+
+		return nil, err
+	}
+
+	if err := lockFile.Truncate(0); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	if _, err := fmt.Fprintf(lockFile, "%d\n", os.Getpid()); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	return lockFile, nil
+}
@@ -0,0 +1,28 @@
+package file_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	r := require.New(t)
+
+	targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+
+	lockFile, err := file.Lock(targetFile)
+	r.NoError(err)
+
+	// flock conflicts between open files, even in the same process
+	_, err = file.Lock(targetFile)
+	r.ErrorIs(err, file.ErrLocked)
+
+	r.NoError(lockFile.Close())
+
+	lockFile, err = file.Lock(targetFile)
+	r.NoError(err)
+	r.NoError(lockFile.Close())
+}
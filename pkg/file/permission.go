@@ -0,0 +1,56 @@
+package file
+
+import "os"
+
+const (
+	DefaultFileMode      uint32 = 0644
+	DefaultDirectoryMode uint32 = 0755
+)
+
+// LemonPermission is the permission bits and the ownership of a node.
+// Fields are nil in JSON files written before they existed, the defaults are used in that case.
+type LemonPermission struct {
+	Mode *uint32 `json:"mode,omitempty"`
+	Uid  *uint32 `json:"uid,omitempty"`
+	Gid  *uint32 `json:"gid,omitempty"`
+}
+
+func NewLemonPermission(mode, uid, gid uint32) LemonPermission {
+	mode &= 07777
+
+	return LemonPermission{Mode: &mode, Uid: &uid, Gid: &gid}
+}
+
+// Perm returns the permission bits, or defaultMode if they are not set
+func (p *LemonPermission) Perm(defaultMode uint32) uint32 {
+	if p.Mode == nil {
+		return defaultMode
+	}
+
+	return *p.Mode
+}
+
+// Owner returns the uid and gid, the owner of the lemonfs process is used if they are not set
+func (p *LemonPermission) Owner() (uint32, uint32) {
+	uid := uint32(os.Getuid())
+	if p.Uid != nil {
+		uid = *p.Uid
+	}
+
+	gid := uint32(os.Getgid())
+	if p.Gid != nil {
+		gid = *p.Gid
+	}
+
+	return uid, gid
+}
+
+func (p *LemonPermission) Chmod(mode uint32) {
+	mode &= 07777
+	p.Mode = &mode
+}
+
+func (p *LemonPermission) Chown(uid, gid uint32) {
+	p.Uid = &uid
+	p.Gid = &gid
+}
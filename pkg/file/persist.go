@@ -0,0 +1,121 @@
+package file
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// writeFileAtomic replaces path with content, path contains either the old or the new content even if we crash in between.
+// The content is written to a temporary file in the same directory, synced and renamed over path.
+// An error means that path still holds the old content.
+func writeFileAtomic(path string, content []byte) (err error) {
+	dir := filepath.Dir(path)
+
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	// keep the permission of the existing file
+	mode := os.FileMode(0644)
+	if stat, statErr := os.Stat(path); statErr == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	if err = tmpFile.Chmod(mode); err != nil {
+		return err
+	}
+
+	if _, err = tmpFile.Write(content); err != nil {
+		return err
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename durable, path already holds the new content, so a failure is not returned to be rolled back
+	if err := syncDir(dir); err != nil {
+		log.Printf("Failed to sync %s after replacing %s: %v", dir, path, err)
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+
+	return dirFile.Sync()
+}
+
+// RLockTree must be called by every operation before it reads the tree, and RUnlockTree when it's done.
+// Operations which change the tree call LockTree instead, so the storage never serializes a tree being changed and
+// the changes are persisted in order. The tree lock is also held exclusively by the storage while it serializes the tree
+// in the background, and by the watcher while it reloads the tree, it can't be taken twice by one operation.
+// Trees without storage have no tree lock.
+func (c *LemonDirectoryChild) RLockTree() {
+	if lock := c.root().treeLock; lock != nil {
+		lock.RLock()
+	}
+}
+
+func (c *LemonDirectoryChild) RUnlockTree() {
+	if lock := c.root().treeLock; lock != nil {
+		lock.RUnlock()
+	}
+}
+
+// LockTree must be called by every operation before it changes the tree and persists the change, and UnlockTree when it's done
+func (c *LemonDirectoryChild) LockTree() {
+	if lock := c.root().treeLock; lock != nil {
+		lock.Lock()
+	}
+}
+
+func (c *LemonDirectoryChild) UnlockTree() {
+	if lock := c.root().treeLock; lock != nil {
+		lock.Unlock()
+	}
+}
+
+// Errno converts an error returned by Persist to the errno returned to the FUSE caller
+func Errno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+
+	log.Printf("Failed to persist the tree: %v", err)
+
+	switch {
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return syscall.ENOSPC
+	case errors.Is(err, syscall.EROFS):
+		return syscall.EROFS
+	case errors.Is(err, syscall.EINVAL):
+		return syscall.EINVAL
+	default:
+		return syscall.EIO
+	}
+}
@@ -0,0 +1,63 @@
+package file
+
+import (
+	"maps"
+	"syscall"
+)
+
+// Snapshot is a copy of a node taken before an in-memory change, it is used to roll back the change if it can't be persisted.
+// Children of a directory are not copied, only the list of them.
+type Snapshot struct {
+	node *LemonDirectoryChild
+
+	child     LemonDirectoryChild
+	file      LemonFile
+	directory LemonDirectory
+	symlink   LemonSymlink
+}
+
+func (c *LemonDirectoryChild) Snapshot() *Snapshot {
+	snapshot := &Snapshot{node: c, child: *c}
+
+	if c.File != nil {
+		snapshot.file = *c.File
+		snapshot.file.Xattrs = maps.Clone(c.File.Xattrs)
+	}
+
+	if c.Directory != nil {
+		snapshot.directory = *c.Directory
+		snapshot.directory.Xattrs = maps.Clone(c.Directory.Xattrs)
+	}
+
+	if c.Symlink != nil {
+		snapshot.symlink = *c.Symlink
+	}
+
+	return snapshot
+}
+
+// Restore puts the node back to the state when the snapshot was taken
+func (s *Snapshot) Restore() {
+	*s.node = s.child
+
+	if s.node.File != nil {
+		*s.node.File = s.file
+	}
+
+	if s.node.Directory != nil {
+		*s.node.Directory = s.directory
+	}
+
+	if s.node.Symlink != nil {
+		*s.node.Symlink = s.symlink
+	}
+}
+
+// Rollback restores the snapshots and converts err to the errno returned to the FUSE caller
+func Rollback(err error, snapshots ...*Snapshot) syscall.Errno {
+	for _, snapshot := range snapshots {
+		snapshot.Restore()
+	}
+
+	return Errno(err)
+}
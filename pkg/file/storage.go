@@ -0,0 +1,261 @@
+package file
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Storage persists a tree. The FUSE operations change the tree in memory and pass the changes to the storage of its root,
+// so other formats can be added without touching them.
+type Storage interface {
+	// Load reads the tree, the storage is set on its root
+	Load() (*LemonDirectoryChild, error)
+	// Persist is called after every change of the tree of root, changes describe what has been changed.
+	// A storage may persist the whole tree or the changes only, the change is rolled back if an error is returned.
+	Persist(root *LemonDirectoryChild, changes []*Change) error
+	// Flush makes the persisted changes durable, if the storage caches them
+	Flush() error
+	// Close flushes the cached changes and releases the storage
+	Close() error
+}
+
+// SetStorage makes storage persist the tree of the root c, it must be called before the tree is mounted
+func (c *LemonDirectoryChild) SetStorage(storage Storage) {
+	c.storage = storage
+
+	if c.treeLock == nil {
+		c.treeLock = &sync.RWMutex{}
+	}
+}
+
+// Persist passes the changes of the tree to its storage, it does nothing if the tree has no storage
+func (c *LemonDirectoryChild) Persist(changes ...*Change) error {
+	root := c.root()
+	if root.storage == nil {
+		return nil
+	}
+
+	return root.storage.Persist(root, changes)
+}
+
+// Flush makes the persisted changes of the tree durable, it does nothing if the tree has no storage
+func (c *LemonDirectoryChild) Flush() error {
+	root := c.root()
+	if root.storage == nil {
+		return nil
+	}
+
+	return root.storage.Flush()
+}
+
+// FileStorage stores a tree in a JSON, YAML or TOML file, by default the whole file is rewritten on every change.
+// It can be combined with a write-back cache, a journal and a watcher.
+type FileStorage struct {
+	path      string
+	format    Format
+	writeBack *WriteBack
+	watcher   *Watcher
+
+	// stored is the size of the file when it was last loaded or written
+	stored atomic.Uint64
+}
+
+func NewFileStorage(path string, format Format) *FileStorage {
+	return &FileStorage{path: path, format: format}
+}
+
+// NewJSONStorage returns a storage of the JSON file at path
+func NewJSONStorage(path string) *FileStorage {
+	return NewFileStorage(path, JSON)
+}
+
+// Path returns the path of the file
+func (s *FileStorage) Path() string {
+	return s.path
+}
+
+func (s *FileStorage) Format() Format {
+	return s.format
+}
+
+// Load reads the file, a file without a tree is loaded as an empty directory.
+// With a journal its changes are replayed and written at the next flush.
+func (s *FileStorage) Load() (*LemonDirectoryChild, error) {
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stored.Store(uint64(len(content)))
+
+	root := &LemonDirectoryChild{}
+	if err := s.format.Unmarshal(content, root); err != nil {
+		return nil, err
+	}
+	if root.File == nil && root.Directory == nil && root.Symlink == nil {
+		log.Println("jsonRoot is nil, create empty directory")
+
+		root.Type = "directory"
+		root.Directory = &LemonDirectory{
+			Type:    "directory",
+			Content: []LemonDirectoryChild{},
+		}
+	}
+	if !root.IsDirectory() {
+		return nil, errors.New("the root is not a directory")
+	}
+
+	root.ApplyParent(nil)
+	root.ResolveHardLinks()
+	root.SetStorage(s)
+
+	if s.writeBack != nil && s.writeBack.journal != nil {
+		replayed, err := s.writeBack.journal.Replay(root, content)
+		if err != nil {
+			return nil, err
+		}
+
+		// checkpoint the replayed changes
+		if replayed > 0 {
+			log.Printf("Replayed %d changes from the journal", replayed)
+			if err := root.Persist(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return root, nil
+}
+
+// Persist writes the whole tree, or marks it dirty with the write-back cache and records the changes in the journal
+func (s *FileStorage) Persist(root *LemonDirectoryChild, changes []*Change) error {
+	if s.writeBack != nil {
+		return s.writeBack.write(root, changes)
+	}
+
+	content, err := s.format.Marshal(root)
+	if err != nil {
+		return err
+	}
+
+	return s.write(content)
+}
+
+// Flush writes the changes cached by the write-back cache.
+// It does nothing without the write-back cache, or with a journal, as every change is persisted immediately.
+func (s *FileStorage) Flush() error {
+	if s.writeBack != nil && s.writeBack.journal == nil {
+		return s.writeBack.Flush()
+	}
+
+	return nil
+}
+
+// Close writes the changes cached by the write-back cache and closes the journal
+func (s *FileStorage) Close() error {
+	if s.writeBack != nil {
+		return s.writeBack.Close()
+	}
+
+	return nil
+}
+
+// write replaces the file with content, the watcher doesn't reload it.
+// It fails with ErrConflict if the file has been changed by another process and the watcher hasn't reloaded it yet.
+func (s *FileStorage) write(content []byte) error {
+	if s.watcher != nil {
+		if s.watcher.conflict() {
+			return ErrConflict
+		}
+
+		s.watcher.expect(content)
+	}
+
+	err := writeFileAtomic(s.path, content)
+	if err == nil {
+		s.stored.Store(uint64(len(content)))
+	}
+
+	if s.watcher != nil {
+		s.watcher.written(content, err)
+	}
+
+	return err
+}
+
+func (s *FileStorage) size() uint64 {
+	return s.stored.Load()
+}
+
+// MemoryStorage keeps a tree in memory only and records its changes, it is meant for tests
+type MemoryStorage struct {
+	root *LemonDirectoryChild
+
+	lock    sync.Mutex
+	changes []*Change
+	err     error
+}
+
+// NewMemoryStorage returns a storage holding root, or an empty directory if root is nil
+func NewMemoryStorage(root *LemonDirectoryChild) *MemoryStorage {
+	if root == nil {
+		root = &LemonDirectoryChild{
+			Type: "directory",
+			Directory: &LemonDirectory{
+				Type:    "directory",
+				Content: []LemonDirectoryChild{},
+			},
+		}
+	}
+
+	return &MemoryStorage{root: root}
+}
+
+func (s *MemoryStorage) Load() (*LemonDirectoryChild, error) {
+	s.root.ApplyParent(nil)
+	s.root.ResolveHardLinks()
+	s.root.SetStorage(s)
+
+	return s.root, nil
+}
+
+// Persist records the changes, or returns the error set by Fail
+func (s *MemoryStorage) Persist(root *LemonDirectoryChild, changes []*Change) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.changes = append(s.changes, changes...)
+
+	return nil
+}
+
+func (s *MemoryStorage) Flush() error {
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// Fail makes Persist return err, or succeed again if err is nil
+func (s *MemoryStorage) Fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+}
+
+// Changes returns the changes persisted so far
+func (s *MemoryStorage) Changes() []*Change {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]*Change{}, s.changes...)
+}
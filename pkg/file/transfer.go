@@ -0,0 +1,238 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Import reads the directory dir into a tree, keeping the names, content, timestamps, modes, owners, symlinks and
+// hard links. Nodes the schema can't store, like devices, sockets and pipes, are skipped and their paths returned.
+func Import(dir string) (*LemonDirectoryChild, []string, error) {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	importer := &importer{ids: map[[2]uint64]uint64{}}
+
+	root, err := importer.node(dir, "", info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root.ApplyParent(nil)
+	root.ResolveHardLinks()
+
+	return root, importer.skipped, nil
+}
+
+type importer struct {
+	// ids are the IDs of the files with hard links by their device and inode number
+	ids     map[[2]uint64]uint64
+	skipped []string
+}
+
+// node reads the node at path, it returns nil if the node is skipped
+func (im *importer) node(path string, name string, info os.FileInfo) (*LemonDirectoryChild, error) {
+	attr := fuse.ToAttr(info)
+	if attr == nil {
+		return nil, fmt.Errorf("%s: the attributes can't be read", path)
+	}
+	permission := NewLemonPermission(attr.Mode, attr.Uid, attr.Gid)
+
+	switch {
+	case info.Mode().IsRegular():
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		f := &LemonFile{
+			LemonPermission: permission,
+			Type:            "file",
+			Name:            name,
+			Content:         string(content),
+			CreatedAt:       attr.Ctime,
+			LastAccessedAt:  attr.Atime,
+			LastModifiedAt:  attr.Mtime,
+		}
+
+		// every link is stored as a whole file, ResolveHardLinks merges them by their ID
+		if attr.Nlink > 1 {
+			key := [2]uint64{uint64(fuse.ToStatT(info).Dev), attr.Ino}
+			if _, ok := im.ids[key]; !ok {
+				im.ids[key] = uint64(len(im.ids)) + 1
+			}
+			f.ID = im.ids[key]
+		}
+
+		return &LemonDirectoryChild{Type: "file", File: f}, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &LemonDirectoryChild{
+			Type: "symlink",
+			Symlink: &LemonSymlink{
+				LemonPermission: permission,
+				Type:            "symlink",
+				Name:            name,
+				Target:          target,
+				CreatedAt:       attr.Ctime,
+				LastAccessedAt:  attr.Atime,
+				LastModifiedAt:  attr.Mtime,
+			},
+		}, nil
+	case info.IsDir():
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := &LemonDirectory{
+			LemonPermission: permission,
+			Type:            "directory",
+			Name:            name,
+			Content:         []LemonDirectoryChild{},
+			CreatedAt:       attr.Ctime,
+			LastAccessedAt:  attr.Atime,
+			LastModifiedAt:  attr.Mtime,
+		}
+
+		for _, entry := range entries {
+			childPath := filepath.Join(path, entry.Name())
+
+			childInfo, err := os.Lstat(childPath)
+			if err != nil {
+				return nil, err
+			}
+
+			child, err := im.node(childPath, entry.Name(), childInfo)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				dir.Content = append(dir.Content, *child)
+			}
+		}
+
+		return &LemonDirectoryChild{Type: "directory", Directory: dir}, nil
+	default:
+		im.skipped = append(im.skipped, path)
+		return nil, nil
+	}
+}
+
+// Export writes the tree of root into the directory dir, which must not exist or be empty.
+// Names, content, timestamps, modes, symlinks and hard links are kept, owners only if the caller is root.
+// The change time can't be set and symlinks get the current timestamps.
+func Export(root *LemonDirectoryChild, dir string) error {
+	if !root.IsDirectory() {
+		return errors.New("the root is not a directory")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Mkdir(dir, 0700)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+
+	exporter := &exporter{links: map[*LemonFile]string{}, chown: os.Geteuid() == 0}
+
+	return exporter.directory(root, dir)
+}
+
+type exporter struct {
+	// links are the paths of the exported files with hard links
+	links map[*LemonFile]string
+	chown bool
+}
+
+// directory writes the children of node into the existing directory path, then sets its attributes.
+// The attributes are set last, as writing the children changes the modification time and may need write permission.
+func (ex *exporter) directory(node *LemonDirectoryChild, path string) error {
+	for i := range node.Directory.Content {
+		child := &node.Directory.Content[i]
+
+		name := child.Name()
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return fmt.Errorf("%s: an invalid name %q", node.Path(), name)
+		}
+
+		if err := ex.node(child, filepath.Join(path, name)); err != nil {
+			return err
+		}
+	}
+
+	return ex.setAttr(path, &node.Directory.LemonPermission, DefaultDirectoryMode, node.Directory.LastAccessedAt, node.Directory.LastModifiedAt)
+}
+
+func (ex *exporter) node(node *LemonDirectoryChild, path string) error {
+	switch {
+	case node.IsFile():
+		if first, ok := ex.links[node.File]; ok {
+			return os.Link(first, path)
+		}
+		if node.File.Links() > 1 {
+			ex.links[node.File] = path
+		}
+
+		if err := os.WriteFile(path, []byte(node.File.Content), 0600); err != nil {
+			return err
+		}
+
+		return ex.setAttr(path, &node.File.LemonPermission, DefaultFileMode, node.File.LastAccessedAt, node.File.LastModifiedAt)
+	case node.IsDirectory():
+		if err := os.Mkdir(path, 0700); err != nil {
+			return err
+		}
+
+		return ex.directory(node, path)
+	case node.IsSymlink():
+		if err := os.Symlink(node.Symlink.Target, path); err != nil {
+			return err
+		}
+
+		if ex.chown {
+			uid, gid := node.Symlink.Owner()
+			return os.Lchown(path, int(uid), int(gid))
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%s: unknown type %q", node.Path(), node.Type)
+	}
+}
+
+func (ex *exporter) setAttr(path string, permission *LemonPermission, defaultMode uint32, atime, mtime uint64) error {
+	if ex.chown {
+		uid, gid := permission.Owner()
+		if err := os.Lchown(path, int(uid), int(gid)); err != nil {
+			return err
+		}
+	}
+
+	// the mode is set with chmod(2), os.Chmod maps the setuid, setgid and sticky bits differently
+	if err := syscall.Chmod(path, permission.Perm(defaultMode)); err != nil {
+		return &os.PathError{Op: "chmod", Path: path, Err: err}
+	}
+
+	return os.Chtimes(path, time.Unix(int64(atime), 0), time.Unix(int64(mtime), 0))
+}
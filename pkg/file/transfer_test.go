@@ -0,0 +1,80 @@
+package file_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportExport(t *testing.T) {
+	r := require.New(t)
+
+	src := filepath.Join(t.TempDir(), "src")
+	r.NoError(os.MkdirAll(filepath.Join(src, "d", "e"), 0755))
+	r.NoError(os.WriteFile(filepath.Join(src, "a"), []byte("hello\n"), 0640))
+	r.NoError(os.WriteFile(filepath.Join(src, "d", "binary"), []byte{0x1f, 0x8b, 0xff}, 0600))
+	r.NoError(os.Link(filepath.Join(src, "a"), filepath.Join(src, "d", "b")))
+	r.NoError(os.Symlink("../a", filepath.Join(src, "d", "c")))
+	r.NoError(syscall.Mkfifo(filepath.Join(src, "fifo"), 0644))
+	r.NoError(os.Chmod(filepath.Join(src, "d", "e"), 0700))
+
+	modified := time.Unix(1700000000, 0)
+	r.NoError(os.Chtimes(filepath.Join(src, "a"), modified, modified))
+	r.NoError(os.Chtimes(filepath.Join(src, "d"), modified, modified))
+
+	root, skipped, err := file.Import(src)
+	r.NoError(err)
+	r.Equal([]string{filepath.Join(src, "fifo")}, skipped)
+
+	// store and load the tree, like lemonfs import and lemonfs export do
+	jsonFile := filepath.Join(t.TempDir(), "lemonfs.json")
+	r.NoError(file.NewJSONStorage(jsonFile).Persist(root, nil))
+	root, err = file.NewJSONStorage(jsonFile).Load()
+	r.NoError(err)
+
+	a := findChild(root, "a")
+	r.Equal("hello\n", a.File.Content)
+	r.Equal(uint32(0640), a.File.Perm(0))
+	r.Equal(uint64(1700000000), a.File.LastModifiedAt)
+	r.Same(a.File, findChild(findChild(root, "d"), "b").File)
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	r.NoError(file.Export(root, dst))
+
+	content, err := os.ReadFile(filepath.Join(dst, "a"))
+	r.NoError(err)
+	r.Equal("hello\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dst, "d", "binary"))
+	r.NoError(err)
+	r.Equal([]byte{0x1f, 0x8b, 0xff}, content)
+
+	info, err := os.Stat(filepath.Join(dst, "a"))
+	r.NoError(err)
+	r.Equal(os.FileMode(0640), info.Mode().Perm())
+	r.Equal(modified, info.ModTime())
+
+	linked, err := os.Stat(filepath.Join(dst, "d", "b"))
+	r.NoError(err)
+	r.True(os.SameFile(info, linked))
+
+	target, err := os.Readlink(filepath.Join(dst, "d", "c"))
+	r.NoError(err)
+	r.Equal("../a", target)
+
+	info, err = os.Stat(filepath.Join(dst, "d"))
+	r.NoError(err)
+	r.Equal(modified, info.ModTime())
+
+	info, err = os.Stat(filepath.Join(dst, "d", "e"))
+	r.NoError(err)
+	r.Equal(os.FileMode(0700), info.Mode().Perm())
+
+	// the target must be empty
+	r.Error(file.Export(root, dst))
+}
@@ -0,0 +1,39 @@
+package file
+
+import "encoding/json"
+
+// sizer is implemented by storages which know the size of the tree they have stored
+type sizer interface {
+	// size returns the size of the tree when it was last loaded or written, in the format of the storage
+	size() uint64
+}
+
+// Usage returns the size of the stored tree and the number of nodes in it, including the root.
+// The size is the one of the last write of the storage, so it isn't serialized on every call,
+// a tree without such a storage is serialized as JSON.
+func (c *LemonDirectoryChild) Usage() (uint64, uint64, error) {
+	root := c.root()
+
+	if storage, ok := root.storage.(sizer); ok {
+		return storage.size(), root.countNodes(), nil
+	}
+
+	jsonContent, err := json.Marshal(root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(len(jsonContent)), root.countNodes(), nil
+}
+
+func (c *LemonDirectoryChild) countNodes() uint64 {
+	count := uint64(1)
+
+	if c.IsDirectory() {
+		for i := range c.Directory.Content {
+			count += c.Directory.Content[i].countNodes()
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,376 @@
+package file
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is how often the watcher checks whether the target file has been changed by another process
+const DefaultReloadInterval = time.Second
+
+// DifferenceKind tells how a node differs between the tree in memory and the reloaded tree
+type DifferenceKind int
+
+const (
+	// DifferenceAdded is a node which is only in the reloaded tree
+	DifferenceAdded DifferenceKind = iota
+	// DifferenceRemoved is a node which is only in the tree in memory
+	DifferenceRemoved
+	// DifferenceChanged is a node whose content or attributes have changed, or a directory whose entries have changed
+	DifferenceChanged
+)
+
+// Difference is a node changed by a reload, the children of an added or removed directory are not reported
+type Difference struct {
+	Path string
+	Kind DifferenceKind
+}
+
+// ErrConflict is returned by Check, and by writes of the file, if the file has been changed by another process
+// while the write-back cache holds changes which haven't been written yet
+var ErrConflict = errors.New("the file has been changed by another process while changes of this process are pending")
+
+// Watcher polls a JSON file and reloads the tree of root when it is changed by another process.
+// The tree is updated in place, so nodes which still exist keep their File, Directory and Symlink.
+// The file is not reloaded while the write-back cache holds changes which haven't been written yet, and the changes
+// aren't written over the file changed by another process either: flushes fail with ErrConflict, and the file is
+// checked again at every interval, until it holds the content last written by this process again.
+type Watcher struct {
+	storage  *FileStorage
+	root     *LemonDirectoryChild
+	path     string
+	interval time.Duration
+	onReload func([]Difference)
+
+	// lock protects the fields below
+	lock sync.Mutex
+	// info is the state of the file when it was last checked
+	info os.FileInfo
+	// last is the checksum of the last content written by this process, writing is the checksums being written
+	last    [sha256.Size]byte
+	writing map[[sha256.Size]byte]int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWatcher watches the JSON file of storage, root is the tree loaded from it.
+// onReload is called with the differences after every reload, outside the tree lock.
+func NewWatcher(storage *FileStorage, root *LemonDirectoryChild, interval time.Duration, onReload func([]Difference)) (*Watcher, error) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	info, err := os.Stat(storage.path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		storage:  storage,
+		root:     root,
+		path:     storage.path,
+		interval: interval,
+		onReload: onReload,
+		info:     info,
+		writing:  map[[sha256.Size]byte]int{},
+	}
+	storage.watcher = w
+
+	return w, nil
+}
+
+// Start polls the file in the background until Close is called
+func (w *Watcher) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	go w.run()
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.Check(); err != nil {
+				log.Printf("Failed to reload %s: %v", w.path, err)
+			}
+		}
+	}
+}
+
+// Close stops polling, it waits for a running reload to finish
+func (w *Watcher) Close() {
+	if w.stop == nil {
+		return
+	}
+
+	close(w.stop)
+	<-w.done
+	w.stop = nil
+}
+
+// expect tells the watcher that content is being written to the file by this process, it must not be reloaded
+func (w *Watcher) expect(content []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.writing[sha256.Sum256(content)]++
+}
+
+// written tells the watcher that writing content has finished
+func (w *Watcher) written(content []byte, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	sum := sha256.Sum256(content)
+	w.writing[sum]--
+	if w.writing[sum] <= 0 {
+		delete(w.writing, sum)
+	}
+
+	if err == nil {
+		w.last = sum
+	}
+}
+
+// modified reports whether the file has been replaced or modified since it was last checked
+func (w *Watcher) modified() (os.FileInfo, bool, error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	unchanged := os.SameFile(info, w.info) && info.ModTime().Equal(w.info.ModTime()) && info.Size() == w.info.Size()
+
+	return info, !unchanged, nil
+}
+
+// conflict reports whether the file has been changed by another process since it was last checked,
+// the tree of this process must not be written over it then
+func (w *Watcher) conflict() bool {
+	_, modified, err := w.modified()
+	if err != nil || !modified {
+		return false
+	}
+
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return false
+	}
+
+	return !w.ours(content)
+}
+
+// ours reports whether content has been written by this process
+func (w *Watcher) ours(content []byte) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	sum := sha256.Sum256(content)
+
+	return sum == w.last || w.writing[sum] > 0
+}
+
+// Check reloads the tree if the file has been changed by another process since the last check.
+// A file which can't be parsed is reported once and the tree is kept until the file is changed again.
+// A file which can't be reloaded because of pending changes is checked again.
+func (w *Watcher) Check() error {
+	info, modified, err := w.modified()
+	if err != nil || !modified {
+		return err
+	}
+
+	differences, err := w.reload()
+	if errors.Is(err, ErrConflict) {
+		return err
+	}
+
+	w.lock.Lock()
+	w.info = info
+	w.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if len(differences) > 0 && w.onReload != nil {
+		w.onReload(differences)
+	}
+
+	return nil
+}
+
+// reload reads the file and merges it into the tree, the file is read under the tree lock
+// so it can't be overwritten by this process between reading and merging
+func (w *Watcher) reload() ([]Difference, error) {
+	writeBack := w.storage.writeBack
+	if writeBack != nil {
+		writeBack.flushLock.Lock()
+		defer writeBack.flushLock.Unlock()
+	}
+
+	w.root.treeLock.Lock()
+	defer w.root.treeLock.Unlock()
+
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.ours(content) {
+		return nil, nil
+	}
+
+	// the changes the callers have been told succeeded would be lost
+	if writeBack != nil && writeBack.pending() {
+		return nil, ErrConflict
+	}
+
+	reloaded := &LemonDirectoryChild{}
+	if err := w.storage.format.Unmarshal(content, reloaded); err != nil {
+		return nil, err
+	}
+	if !reloaded.IsDirectory() {
+		return nil, errors.New("the root is not a directory")
+	}
+
+	reloaded.ApplyParent(nil)
+	reloaded.ResolveHardLinks()
+
+	differences := w.root.merge(reloaded, "/", map[*LemonFile]*LemonFile{})
+	w.root.ApplyParent(nil)
+
+	if writeBack != nil {
+		if err := writeBack.reloaded(content); err != nil {
+			return differences, err
+		}
+	}
+
+	w.lock.Lock()
+	w.last = sha256.Sum256(content)
+	w.lock.Unlock()
+
+	return differences, nil
+}
+
+// merge makes the node the same as the reloaded node of the same type and returns the differences.
+// files maps the files of the reloaded tree to the files kept from the tree in memory, so hard links stay shared.
+func (c *LemonDirectoryChild) merge(reloaded *LemonDirectoryChild, path string, files map[*LemonFile]*LemonFile) []Difference {
+	var differences []Difference
+
+	switch {
+	case c.IsFile():
+		if kept, ok := files[reloaded.File]; ok {
+			// another link of the file has already been merged, the kernel inode of this link must be replaced
+			// if it has kept another file
+			if kept != c.File {
+				c.File = kept
+				differences = append(differences,
+					Difference{Path: path, Kind: DifferenceRemoved}, Difference{Path: path, Kind: DifferenceAdded})
+			}
+			break
+		}
+
+		files[reloaded.File] = c.File
+		if !reflect.DeepEqual(*c.File, *reloaded.File) {
+			*c.File = *reloaded.File
+			differences = append(differences, Difference{Path: path, Kind: DifferenceChanged})
+		}
+	case c.IsSymlink():
+		if *c.Symlink != *reloaded.Symlink {
+			*c.Symlink = *reloaded.Symlink
+			differences = append(differences, Difference{Path: path, Kind: DifferenceChanged})
+		}
+	case c.IsDirectory():
+		content, entriesChanged, childDifferences := c.mergeEntries(reloaded, path, files)
+		differences = append(differences, childDifferences...)
+
+		attributes, reloadedAttributes := *c.Directory, *reloaded.Directory
+		attributes.Content, reloadedAttributes.Content = nil, nil
+		if entriesChanged || !reflect.DeepEqual(attributes, reloadedAttributes) {
+			differences = append(differences, Difference{Path: path, Kind: DifferenceChanged})
+		}
+
+		*c.Directory = *reloaded.Directory
+		c.Directory.Content = content
+	}
+
+	c.LinkName = reloaded.LinkName
+
+	return differences
+}
+
+// mergeEntries merges the children of the directory with the children of the reloaded directory,
+// it returns the new children and whether entries have been added or removed
+func (c *LemonDirectoryChild) mergeEntries(reloaded *LemonDirectoryChild, path string, files map[*LemonFile]*LemonFile) ([]LemonDirectoryChild, bool, []Difference) {
+	var differences []Difference
+	entriesChanged := false
+
+	existing := map[string]*LemonDirectoryChild{}
+	for index := range c.Directory.Content {
+		existing[c.Directory.Content[index].Name()] = &c.Directory.Content[index]
+	}
+
+	content := make([]LemonDirectoryChild, 0, len(reloaded.Directory.Content))
+	for index := range reloaded.Directory.Content {
+		child := &reloaded.Directory.Content[index]
+		childPath := filepath.Join(path, child.Name())
+
+		old, ok := existing[child.Name()]
+		delete(existing, child.Name())
+
+		if ok && old.Type == child.Type && !old.relinked(child) {
+			differences = append(differences, old.merge(child, childPath, files)...)
+			content = append(content, *old)
+			continue
+		}
+
+		if ok {
+			// the entry has been replaced by a node of another type or another file
+			differences = append(differences, Difference{Path: childPath, Kind: DifferenceRemoved})
+		}
+
+		if child.IsFile() {
+			if kept, ok := files[child.File]; ok {
+				child.File = kept
+			} else {
+				files[child.File] = child.File
+			}
+		}
+
+		differences = append(differences, Difference{Path: childPath, Kind: DifferenceAdded})
+		content = append(content, *child)
+		entriesChanged = true
+	}
+
+	for _, child := range c.Directory.Content {
+		if _, ok := existing[child.Name()]; ok {
+			differences = append(differences, Difference{Path: filepath.Join(path, child.Name()), Kind: DifferenceRemoved})
+			entriesChanged = true
+		}
+	}
+
+	return content, entriesChanged, differences
+}
+
+// relinked reports whether a file entry refers to another file in the reloaded tree, its file can't be kept then
+func (c *LemonDirectoryChild) relinked(reloaded *LemonDirectoryChild) bool {
+	return c.IsFile() && c.File.ID != reloaded.File.ID
+}
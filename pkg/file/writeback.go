@@ -0,0 +1,189 @@
+package file
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval is how long changes stay in memory before the write-back cache writes them to the target file
+const DefaultFlushInterval = 5 * time.Second
+
+// WriteBack coalesces the changes of a tree stored by a FileStorage, Persist only marks the tree dirty
+// and the whole tree is written to the target file at most once per interval, or when Flush is called.
+// Changes are not rolled back if they can't be persisted, the error is returned by the next Flush instead.
+// With a journal every change is persisted in the journal immediately, and a flush is a checkpoint which empties the journal.
+type WriteBack struct {
+	storage  *FileStorage
+	interval time.Duration
+	journal  *Journal
+
+	// flushLock makes sure only one flush writes the target file at a time, it is taken before the tree lock
+	flushLock sync.Mutex
+
+	// lock protects the fields below, it is taken after the tree lock
+	lock   sync.Mutex
+	root   *LemonDirectoryChild
+	dirty  bool
+	timer  *time.Timer
+	closed bool
+}
+
+// NewWriteBack enables the write-back cache of storage, it must be called before the tree is loaded
+func NewWriteBack(storage *FileStorage, interval time.Duration) *WriteBack {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+
+	w := &WriteBack{
+		storage:  storage,
+		interval: interval,
+	}
+	storage.writeBack = w
+
+	return w
+}
+
+// SetJournal records the changes in journal, the journal is replayed when the tree is loaded
+func (w *WriteBack) SetJournal(journal *Journal) {
+	w.journal = journal
+}
+
+// write records the changes in the journal and marks the tree of root dirty
+func (w *WriteBack) write(root *LemonDirectoryChild, changes []*Change) error {
+	if w.journal != nil {
+		if err := w.journal.append(changes); err != nil {
+			return err
+		}
+	}
+
+	w.markDirty(root)
+
+	return nil
+}
+
+// markDirty remembers that the tree of root has changed and schedules a flush
+func (w *WriteBack) markDirty(root *LemonDirectoryChild) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.root = root
+	w.dirty = true
+	w.schedule()
+}
+
+// schedule starts the flush timer if it isn't running, w.lock must be held
+func (w *WriteBack) schedule() {
+	if w.closed || w.timer != nil {
+		return
+	}
+
+	w.timer = time.AfterFunc(w.interval, func() {
+		w.lock.Lock()
+		w.timer = nil
+		w.lock.Unlock()
+
+		if err := w.Flush(); err != nil {
+			log.Printf("Failed to flush to the target file, will retry: %v", err)
+		}
+	})
+}
+
+// Flush writes the tree to the target file if it has changed since the last flush
+func (w *WriteBack) Flush() error {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	// the tree is only known once it has been changed
+	w.lock.Lock()
+	root := w.root
+	w.lock.Unlock()
+
+	if root == nil {
+		return nil
+	}
+
+	root.treeLock.Lock()
+
+	w.lock.Lock()
+	dirty := w.dirty
+	w.dirty = false
+	w.lock.Unlock()
+
+	if !dirty {
+		root.treeLock.Unlock()
+		return nil
+	}
+
+	content, err := w.storage.format.Marshal(root)
+
+	// changes made while writing the target file would be lost when the journal is reset
+	if w.journal == nil {
+		root.treeLock.Unlock()
+	}
+
+	if err == nil {
+		err = w.storage.write(content)
+	}
+
+	if err == nil && w.journal != nil {
+		err = w.journal.Reset(content)
+	}
+
+	if w.journal != nil {
+		root.treeLock.Unlock()
+	}
+
+	if err != nil {
+		// keep the changes and try again later
+		w.lock.Lock()
+		w.dirty = true
+		w.schedule()
+		w.lock.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// pending reports whether the tree has changes which haven't been written to the target file yet,
+// with a journal they are the changes recorded since the last checkpoint
+func (w *WriteBack) pending() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.dirty
+}
+
+// reloaded is called after the tree without pending changes has been reloaded from content, the journal starts over from it.
+// The flush lock and the tree lock must be held.
+func (w *WriteBack) reloaded(content []byte) error {
+	if w.journal != nil {
+		return w.journal.Reset(content)
+	}
+
+	return nil
+}
+
+// Close stops the flush timer and writes the pending changes, changes made after Close are written by Flush only.
+// The journal is closed if the changes are written.
+func (w *WriteBack) Close() error {
+	w.lock.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.lock.Unlock()
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if w.journal != nil {
+		return w.journal.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package file
+
+import (
+	"slices"
+
+	"github.com/samber/lo"
+)
+
+// xattrs returns the extended attributes of the node, symbolic links don't have them
+func (c *LemonDirectoryChild) xattrs() *map[string][]byte {
+	if c.IsFile() {
+		return &c.File.Xattrs
+	}
+
+	if c.IsDirectory() {
+		return &c.Directory.Xattrs
+	}
+
+	return nil
+}
+
+// SupportsXattrs reports whether extended attributes can be set on the node
+func (c *LemonDirectoryChild) SupportsXattrs() bool {
+	return c.xattrs() != nil
+}
+
+func (c *LemonDirectoryChild) GetXattr(name string) ([]byte, bool) {
+	xattrs := c.xattrs()
+	if xattrs == nil {
+		return nil, false
+	}
+
+	value, ok := (*xattrs)[name]
+	return value, ok
+}
+
+// SetXattr stores a copy of value, it does nothing on nodes without extended attributes
+func (c *LemonDirectoryChild) SetXattr(name string, value []byte) {
+	xattrs := c.xattrs()
+	if xattrs == nil {
+		return
+	}
+
+	if *xattrs == nil {
+		*xattrs = map[string][]byte{}
+	}
+
+	(*xattrs)[name] = slices.Clone(value)
+}
+
+// RemoveXattr removes the attribute and reports whether it existed
+func (c *LemonDirectoryChild) RemoveXattr(name string) bool {
+	xattrs := c.xattrs()
+	if xattrs == nil {
+		return false
+	}
+
+	if _, ok := (*xattrs)[name]; !ok {
+		return false
+	}
+
+	delete(*xattrs, name)
+	return true
+}
+
+// XattrNames returns the sorted names of the extended attributes
+func (c *LemonDirectoryChild) XattrNames() []string {
+	xattrs := c.xattrs()
+	if xattrs == nil {
+		return []string{}
+	}
+
+	names := lo.Keys(*xattrs)
+	slices.Sort(names)
+
+	return names
+}
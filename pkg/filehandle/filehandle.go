@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/v2/fs"
@@ -14,32 +15,59 @@ import (
 type LemonFileHandle struct {
 	file  *file.LemonDirectoryChild
 	flags uint32
+	// dirty is set if the file has been changed through this handle since the last successful flush,
+	// it is atomic as the inode sets it while changing the file through the handle, see MarkDirty
+	dirty atomic.Bool
+	// readOnly is set if the file is opened on a read-only mount
+	readOnly bool
 
 	rwLock sync.RWMutex
 }
 
-func NewLemonFileHandle(file *file.LemonDirectoryChild, flags uint32) *LemonFileHandle {
+func NewLemonFileHandle(file *file.LemonDirectoryChild, flags uint32, readOnly bool) *LemonFileHandle {
 	return &LemonFileHandle{
-		file:  file,
-		flags: flags,
+		file:     file,
+		flags:    flags,
+		readOnly: readOnly,
 	}
 }
 
+// Writable reports whether the file has been opened for writing
+func (fh *LemonFileHandle) Writable() bool {
+	return fh.flags&syscall.O_ACCMODE != syscall.O_RDONLY
+}
+
 // type check
 var _ fs.FileReader = (*LemonFileHandle)(nil)
 var _ fs.FileWriter = (*LemonFileHandle)(nil)
 var _ fs.FileSetattrer = (*LemonFileHandle)(nil)
+var _ fs.FileFlusher = (*LemonFileHandle)(nil)
+var _ fs.FileFsyncer = (*LemonFileHandle)(nil)
+var _ fs.FileReleaser = (*LemonFileHandle)(nil)
 
 func (fh *LemonFileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
 	fh.rwLock.Lock()
 	defer fh.rwLock.Unlock()
 
-	// append mode
+	fh.file.LockTree()
+	defer fh.file.UnlockTree()
+
+	if fh.readOnly {
+		return 0, syscall.EROFS
+	}
+
+	// append mode, the kernel may pass a stale offset, always write at the end
 	if fh.flags&syscall.O_APPEND != 0 {
 		log.Printf("Write %s at %d, %d bytes, append mode", fh.file.Path(), off, len(data))
 
+		snapshot := fh.file.Snapshot()
+		end := int64(len(fh.file.File.Content))
 		fh.file.File.Content += string(data)
-		fh.file.WriteToFile()
+		fh.file.Touch()
+		if err := fh.file.Persist(file.WriteChange(fh.file, end, data)); err != nil {
+			return 0, file.Rollback(err, snapshot)
+		}
+		fh.dirty.Store(true)
 
 		return uint32(len(data)), 0
 	}
@@ -47,8 +75,13 @@ func (fh *LemonFileHandle) Write(ctx context.Context, data []byte, off int64) (u
 	// normal mode
 	log.Printf("Write %s at %d, %d bytes, normal mode", fh.file.Path(), off, len(data))
 
-	fh.file.File.Content = string(data)
-	fh.file.WriteToFile()
+	snapshot := fh.file.Snapshot()
+	fh.file.File.WriteAt(data, off)
+	fh.file.Touch()
+	if err := fh.file.Persist(file.WriteChange(fh.file, off, data)); err != nil {
+		return 0, file.Rollback(err, snapshot)
+	}
+	fh.dirty.Store(true)
 
 	return uint32(len(data)), 0
 }
@@ -57,8 +90,15 @@ func (fh *LemonFileHandle) Read(ctx context.Context, dest []byte, off int64) (fu
 	fh.rwLock.RLock()
 	defer fh.rwLock.RUnlock()
 
+	fh.file.RLockTree()
+	defer fh.file.RUnlockTree()
+
 	log.Printf("Read %s at %d, %d bytes, %d bytes available", fh.file.Path(), off, len(dest), len(fh.file.File.Content))
 
+	if off >= int64(len(fh.file.File.Content)) {
+		return fuse.ReadResultData([]byte{}), 0
+	}
+
 	endIndex := off + int64(len(dest))
 	if endIndex > int64(len(fh.file.File.Content)) {
 		endIndex = int64(len(fh.file.File.Content))
@@ -69,17 +109,90 @@ func (fh *LemonFileHandle) Read(ctx context.Context, dest []byte, off int64) (fu
 	return fuse.ReadResultData([]byte(readBytes)), 0
 }
 
+// MarkDirty records that the file has been changed through this handle by its inode, like by ftruncate,
+// so the change is flushed when the handle is closed
+func (fh *LemonFileHandle) MarkDirty() {
+	fh.dirty.Store(true)
+}
+
+// Setattr is only called for nodes which don't implement fs.NodeSetattrer. LemonInode.Setattr is called instead,
+// it checks the permissions and marks the handle dirty.
 func (fh *LemonFileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
 	fh.rwLock.Lock()
 	defer fh.rwLock.Unlock()
 
-	log.Printf("Set attr of %s\n", fh.file.Path())
+	fh.file.LockTree()
+	defer fh.file.UnlockTree()
+
+	log.Printf("Set attr of %s, valid: %d\n", fh.file.Path(), in.Valid)
+
+	if fh.readOnly {
+		return syscall.EROFS
+	}
+
+	snapshot := fh.file.Snapshot()
+	if errno := fh.file.SetAttr(in); errno != 0 {
+		snapshot.Restore()
+		return errno
+	}
+	if err := fh.file.Persist(file.SetattrChange(fh.file)); err != nil {
+		return file.Rollback(err, snapshot)
+	}
+	fh.dirty.Store(true)
+
+	fh.file.FillAttr(&out.Attr)
+
+	return 0
+}
+
+// Flush is called on every close of the file. If the file has been changed through this handle,
+// the pending changes are flushed by the storage and a failure is reported to close,
+// so a successful close means the changes are persisted, even if the storage caches them.
+func (fh *LemonFileHandle) Flush(ctx context.Context) syscall.Errno {
+	fh.rwLock.Lock()
+	defer fh.rwLock.Unlock()
+
+	log.Printf("Flush %s, dirty: %t", fh.file.Path(), fh.dirty.Load())
+
+	return fh.flush()
+}
+
+// flush writes the pending changes if the handle is dirty, fh.rwLock must be held
+func (fh *LemonFileHandle) flush() syscall.Errno {
+	if !fh.dirty.Load() {
+		return 0
+	}
+
+	if err := fh.file.Flush(); err != nil {
+		return file.Errno(err)
+	}
+	fh.dirty.Store(false)
+
+	return 0
+}
+
+// Fsync makes the pending changes of the whole tree durable in the storage
+func (fh *LemonFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	fh.rwLock.Lock()
+	defer fh.rwLock.Unlock()
 
-	fh.file.File.CreatedAt = in.Ctime
-	fh.file.File.LastModifiedAt = in.Mtime
-	fh.file.File.LastAccessedAt = in.Atime
+	log.Println("Fsync", fh.file.Path())
 
-	fh.file.WriteToFile()
+	if err := fh.file.Flush(); err != nil {
+		return file.Errno(err)
+	}
+	fh.dirty.Store(false)
 
 	return 0
 }
+
+// Release is called when the last reference to the handle is closed, the kernel ignores its result,
+// so it retries writing changes a failed Flush couldn't persist and logs the error.
+func (fh *LemonFileHandle) Release(ctx context.Context) syscall.Errno {
+	fh.rwLock.Lock()
+	defer fh.rwLock.Unlock()
+
+	log.Println("Release", fh.file.Path())
+
+	return fh.flush()
+}
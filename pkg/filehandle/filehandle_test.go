@@ -1,12 +1,15 @@
 package filehandle_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
-	"github.com/hanwen/go-fuse/v2/fs"
-	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/lemonnekogh/lemonfs/internal/fusetest"
 	"github.com/lemonnekogh/lemonfs/pkg/file"
 	"github.com/lemonnekogh/lemonfs/pkg/inode"
 	"github.com/stretchr/testify/require"
@@ -32,16 +35,9 @@ func TestWrite(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.WriteFile(filepath.Join(tmpDir, "a"), []byte("hello"), 0644)
+		err := os.WriteFile(filepath.Join(tmpDir, "a"), []byte("hello"), 0644)
 		r.NoError(err)
 		r.Equal("hello", fileA.Content)
 	})
@@ -66,14 +62,7 @@ func TestWrite(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
 		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_APPEND|os.O_WRONLY, 0644)
 		r.NoError(err)
@@ -83,6 +72,217 @@ func TestWrite(t *testing.T) {
 		r.NoError(err)
 		r.Equal("hello world", fileA.Content)
 	})
+
+	t.Run("large file in multiple chunks", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type: "file",
+			Name: "a",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		// larger than a single FUSE write (128 KiB)
+		content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+		err := os.WriteFile(filepath.Join(tmpDir, "a"), content, 0644)
+		r.NoError(err)
+		r.Equal(string(content), fileA.Content)
+
+		readContent, err := os.ReadFile(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(content, readContent)
+	})
+
+	t.Run("write at offset", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello world",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY, 0644)
+		r.NoError(err)
+		defer f.Close()
+
+		_, err = f.WriteAt([]byte("lemon"), 6)
+		r.NoError(err)
+		r.Equal("hello lemon", fileA.Content)
+
+		_, err = f.WriteAt([]byte("HE"), 0)
+		r.NoError(err)
+		r.Equal("HEllo lemon", fileA.Content)
+	})
+
+	t.Run("write past the end", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY, 0644)
+		r.NoError(err)
+		defer f.Close()
+
+		_, err = f.WriteAt([]byte("world"), 8)
+		r.NoError(err)
+		r.Equal("hello\x00\x00\x00world", fileA.Content)
+	})
+
+	t.Run("timestamps", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{Type: "file", Name: "a", CreatedAt: 1000, LastAccessedAt: 1000, LastModifiedAt: 1000}
+		fileB := &file.LemonFile{Type: "file", Name: "b", CreatedAt: 1000, LastAccessedAt: 1000, LastModifiedAt: 1000}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+					{Type: "file", File: fileB},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		for name, flags := range map[string]int{"a": os.O_WRONLY, "b": os.O_WRONLY | os.O_APPEND} {
+			f, err := os.OpenFile(filepath.Join(tmpDir, name), flags, 0)
+			r.NoError(err)
+			_, err = f.Write([]byte("lemon"))
+			r.NoError(err)
+			r.NoError(f.Close())
+		}
+
+		// the modification and change time advance, the access time is kept
+		for _, f := range []*file.LemonFile{fileA, fileB} {
+			r.Greater(f.LastModifiedAt, uint64(1000))
+			r.Greater(f.CreatedAt, uint64(1000))
+			r.Equal(uint64(1000), f.LastAccessedAt)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello",
+		}
+
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}
+		// can't be written
+		rootChild.SetStorage(file.NewJSONStorage(filepath.Join(t.TempDir(), "not-exists", "lemonfs.json")))
+		root := inode.NewLemonInode(rootChild, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY, 0644)
+		r.NoError(err)
+		defer f.Close()
+
+		_, err = f.WriteAt([]byte("world"), 0)
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal("hello", fileA.Content)
+
+		err = f.Truncate(0)
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal("hello", fileA.Content)
+	})
+
+	t.Run("binary content", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}
+		rootChild.SetStorage(file.NewJSONStorage(targetFile))
+		root := inode.NewLemonInode(rootChild, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		binary := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff}
+		r.NoError(os.WriteFile(filepath.Join(tmpDir, "a.png"), binary, 0644))
+
+		info, err := os.Stat(filepath.Join(tmpDir, "a.png"))
+		r.NoError(err)
+		r.Equal(int64(len(binary)), info.Size())
+
+		content, err := os.ReadFile(filepath.Join(tmpDir, "a.png"))
+		r.NoError(err)
+		r.Equal(binary, content)
+
+		// the content is stored as base64 and loaded unchanged
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+		r.Contains(string(jsonContent), `"encoding":"base64"`)
+
+		loaded := &file.LemonDirectoryChild{}
+		r.NoError(json.Unmarshal(jsonContent, loaded))
+		r.Equal(string(binary), loaded.Directory.Content[0].File.Content)
+	})
 }
 
 func TestRead(t *testing.T) {
@@ -105,14 +305,106 @@ func TestRead(t *testing.T) {
 		},
 	}, nil)
 
-	tmpDir := t.TempDir()
-	server, err := fs.Mount(tmpDir, root, &fs.Options{
-		MountOptions: fuse.MountOptions{
-			Debug: true,
-		},
-	})
-	r.NoError(err)
-	defer server.Unmount()
+	fusetest.Mount(t, root)
 
 	r.Equal("hello", fileA.Content)
 }
+
+func TestFlush(t *testing.T) {
+	newRoot := func(t *testing.T, targetFile string) (*inode.LemonInode, *file.LemonFile) {
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello",
+		}
+
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}
+		rootChild.ApplyParent(nil)
+		storage := file.NewJSONStorage(targetFile)
+		file.NewWriteBack(storage, time.Hour)
+		rootChild.SetStorage(storage)
+		t.Cleanup(func() { storage.Close() })
+
+		return inode.NewLemonInode(rootChild, nil), fileA
+	}
+
+	t.Run("close persists the changes", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+		root, _ := newRoot(t, targetFile)
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY|os.O_APPEND, 0644)
+		r.NoError(err)
+
+		_, err = f.Write([]byte(" world"))
+		r.NoError(err)
+		r.NoError(f.Close())
+
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+		r.Contains(string(jsonContent), "hello world")
+	})
+
+	t.Run("close persists a truncate", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+		root, _ := newRoot(t, targetFile)
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY, 0644)
+		r.NoError(err)
+
+		r.NoError(f.Truncate(2))
+		r.NoError(f.Close())
+
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+		r.Contains(string(jsonContent), `"content":"he"`)
+	})
+
+	t.Run("close reports write-back errors", func(t *testing.T) {
+		r := require.New(t)
+
+		// can't be written
+		root, fileA := newRoot(t, filepath.Join(t.TempDir(), "not-exists", "lemonfs.json"))
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY|os.O_APPEND, 0644)
+		r.NoError(err)
+
+		// the change is only cached, writing succeeds
+		_, err = f.Write([]byte(" world"))
+		r.NoError(err)
+		r.Equal("hello world", fileA.Content)
+
+		r.ErrorIs(f.Sync(), syscall.EIO)
+		r.ErrorIs(f.Close(), syscall.EIO)
+	})
+
+	t.Run("close of unchanged file", func(t *testing.T) {
+		r := require.New(t)
+
+		root, _ := newRoot(t, filepath.Join(t.TempDir(), "not-exists", "lemonfs.json"))
+		tmpDir := fusetest.Mount(t, root)
+
+		// the tree has pending changes which can't be written
+		err := os.Chmod(filepath.Join(tmpDir, "a"), 0600)
+		r.NoError(err)
+
+		f, err := os.Open(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.NoError(f.Close())
+	})
+}
@@ -0,0 +1,199 @@
+package inode
+
+import (
+	"context"
+	"log"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+	"github.com/lemonnekogh/lemonfs/pkg/filehandle"
+	"github.com/samber/lo"
+)
+
+// access masks, same as R_OK, W_OK and X_OK of access(2)
+const (
+	maskRead  uint32 = 4
+	maskWrite uint32 = 2
+	maskExec  uint32 = 1
+)
+
+func (i *LemonInode) Access(ctx context.Context, mask uint32) syscall.Errno {
+	i.rwLock.RLock()
+	defer i.rwLock.RUnlock()
+
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
+	log.Printf("Access %s, mask %d", i.Content.Path(), mask)
+
+	if i.Options.ReadOnly && mask&maskWrite != 0 {
+		return syscall.EROFS
+	}
+
+	return i.checkAccess(ctx, i.Content, mask)
+}
+
+// checkAccess checks if the caller in ctx can access node with mask.
+// It does nothing if the permission checks are left to the kernel.
+func (i *LemonInode) checkAccess(ctx context.Context, node *file.LemonDirectoryChild, mask uint32) syscall.Errno {
+	if i.Options.DefaultPermissions {
+		return 0
+	}
+
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		return 0
+	}
+
+	attr := fuse.Attr{}
+	i.fillAttr(node, &attr)
+
+	if !hasAccess(caller, &attr, mask) {
+		return syscall.EACCES
+	}
+
+	return 0
+}
+
+// checkSetattr checks if the caller in ctx can change the attributes in in, like chmod(2), chown(2), truncate(2)
+// and utimensat(2) do. A file opened for writing can be truncated through its handle without write permission.
+// It does nothing if the permission checks are left to the kernel.
+func (i *LemonInode) checkSetattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn) syscall.Errno {
+	if i.Options.DefaultPermissions {
+		return 0
+	}
+
+	caller, ok := fuse.FromContext(ctx)
+	if !ok || caller.Uid == 0 {
+		return 0
+	}
+
+	attr := fuse.Attr{}
+	i.fillAttr(i.Content, &attr)
+	owner := caller.Uid == attr.Uid
+
+	if _, ok := in.GetMode(); ok && !owner {
+		return syscall.EPERM
+	}
+
+	// only root can give a node away, the owner can change the group to one of its groups
+	if uid, ok := in.GetUID(); ok && (!owner || uid != attr.Uid) {
+		return syscall.EPERM
+	}
+	if gid, ok := in.GetGID(); ok && (!owner || !inGroup(caller, gid)) {
+		return syscall.EPERM
+	}
+
+	if _, ok := in.GetSize(); ok {
+		if handle, ok := fh.(*filehandle.LemonFileHandle); !ok || !handle.Writable() {
+			if errno := i.checkAccess(ctx, i.Content, maskWrite); errno != 0 {
+				return errno
+			}
+		}
+	}
+
+	// explicit times can be set by the owner only, the current time by everyone who can write
+	explicit := in.Valid&fuse.FATTR_ATIME != 0 && in.Valid&fuse.FATTR_ATIME_NOW == 0 ||
+		in.Valid&fuse.FATTR_MTIME != 0 && in.Valid&fuse.FATTR_MTIME_NOW == 0
+	if explicit && !owner {
+		return syscall.EPERM
+	}
+	if in.Valid&(fuse.FATTR_ATIME|fuse.FATTR_MTIME) != 0 && !owner {
+		if errno := i.checkAccess(ctx, i.Content, maskWrite); errno != 0 {
+			return errno
+		}
+	}
+
+	return 0
+}
+
+// hasAccess checks the permission bits of attr like the kernel does
+func hasAccess(caller *fuse.Caller, attr *fuse.Attr, mask uint32) bool {
+	mask &= maskRead | maskWrite | maskExec
+	if mask == 0 {
+		return true
+	}
+
+	if caller.Uid == 0 {
+		// root can do anything, except executing files without any execute bit
+		return mask&maskExec == 0 || attr.IsDir() || attr.Mode&0111 != 0
+	}
+
+	perm := attr.Mode & 0007
+	if caller.Uid == attr.Uid {
+		perm = (attr.Mode >> 6) & 0007
+	} else if inGroup(caller, attr.Gid) {
+		perm = (attr.Mode >> 3) & 0007
+	}
+
+	return perm&mask == mask
+}
+
+// inGroup checks if gid is the primary or a supplementary group of the caller
+func inGroup(caller *fuse.Caller, gid uint32) bool {
+	if caller.Gid == gid {
+		return true
+	}
+
+	return lo.Contains(groups.of(caller.Uid), strconv.Itoa(int(gid)))
+}
+
+// groupsTTL is how long the supplementary groups of a user are cached, changes of the group database are seen after it
+const groupsTTL = 10 * time.Second
+
+// groups caches the supplementary groups of the callers, so they are not looked up on every permission check
+var groups = &groupCache{entries: map[uint32]groupEntry{}}
+
+type groupCache struct {
+	lock    sync.Mutex
+	entries map[uint32]groupEntry
+}
+
+type groupEntry struct {
+	gids    []string
+	expires time.Time
+}
+
+// of returns the supplementary groups of uid, a user which can't be looked up has none
+func (c *groupCache) of(uid uint32) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry, ok := c.entries[uid]; ok && time.Now().Before(entry.expires) {
+		return entry.gids
+	}
+
+	var gids []string
+	if u, err := user.LookupId(strconv.Itoa(int(uid))); err == nil {
+		gids, _ = u.GroupIds()
+	}
+	c.entries[uid] = groupEntry{gids: gids, expires: time.Now().Add(groupsTTL)}
+
+	return gids
+}
+
+// openMask returns the access mask needed to open a file with flags
+func openMask(flags uint32) uint32 {
+	mask := uint32(0)
+
+	switch flags & syscall.O_ACCMODE {
+	case syscall.O_RDONLY:
+		mask = maskRead
+	case syscall.O_WRONLY:
+		mask = maskWrite
+	case syscall.O_RDWR:
+		mask = maskRead | maskWrite
+	}
+
+	if flags&syscall.O_TRUNC != 0 {
+		mask |= maskWrite
+	}
+
+	return mask
+}
@@ -0,0 +1,19 @@
+package inode
+
+import (
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// fillAttr fills the attributes of node into out, with the owner overridden by the options
+func (i *LemonInode) fillAttr(node *file.LemonDirectoryChild, out *fuse.Attr) {
+	node.FillAttr(out)
+
+	if i.Options.Uid != nil {
+		out.Uid = *i.Options.Uid
+	}
+
+	if i.Options.Gid != nil {
+		out.Gid = *i.Options.Gid
+	}
+}
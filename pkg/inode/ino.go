@@ -0,0 +1,40 @@
+package inode
+
+import "sync"
+
+// inodeNumbers keeps the inode numbers of hard linked files, so that every link of a file is the same kernel inode
+type inodeNumbers struct {
+	lock sync.Mutex
+	byID map[uint64]uint64
+}
+
+func newInodeNumbers() *inodeNumbers {
+	return &inodeNumbers{byID: map[uint64]uint64{}}
+}
+
+// of returns the inode number of the file with the given ID, 0 lets go-fuse choose one for files which were never linked.
+// Files loaded with an ID are numbered after it, below the automatic numbers of go-fuse starting at 1<<63.
+func (n *inodeNumbers) of(id uint64) uint64 {
+	if id == 0 {
+		return 0
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	ino, ok := n.byID[id]
+	if !ok {
+		ino = id + 1
+		n.byID[id] = ino
+	}
+
+	return ino
+}
+
+// set makes ino the number of the file with the given ID, a linked file keeps the number of its kernel inode
+func (n *inodeNumbers) set(id uint64, ino uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.byID[id] = ino
+}
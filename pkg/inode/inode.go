@@ -3,6 +3,8 @@ package inode
 import (
 	"context"
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -20,14 +22,42 @@ type LemonInode struct {
 	rwLock sync.RWMutex
 
 	Content *file.LemonDirectoryChild
+	Options Options
+
+	inos *inodeNumbers
+}
+
+// Options changes the behaviour of a mounted tree, children inherit the options of the root inode
+type Options struct {
+	// DefaultPermissions leaves permission checks to the kernel, set it when mounting with the default_permissions option
+	DefaultPermissions bool
+	// Capacity is the size in bytes reported by statfs, DefaultCapacity is used if it is 0
+	Capacity uint64
+	// ReadOnly makes every operation changing the tree fail with EROFS
+	ReadOnly bool
+	// Uid and Gid are reported as the owner of every node if they are set, the owners in the tree are kept
+	Uid *uint32
+	Gid *uint32
+}
+
+// newPermission returns the permission of a new node with mode, owned by the caller
+func newPermission(ctx context.Context, mode uint32) file.LemonPermission {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	if caller, ok := fuse.FromContext(ctx); ok {
+		uid, gid = caller.Uid, caller.Gid
+	}
+
+	return file.NewLemonPermission(mode, uid, gid)
 }
 
-func (i *LemonInode) createFileInode(ctx context.Context, name string, flags uint32) (*fs.Inode, fs.FileHandle) {
+func (i *LemonInode) createFileInode(ctx context.Context, name string, flags uint32, mode uint32) (*fs.Inode, fs.FileHandle, syscall.Errno) {
 	now := uint64(time.Now().Unix())
 
 	newFile := file.LemonDirectoryChild{
 		Type: "file",
 		File: &file.LemonFile{
+			LemonPermission: newPermission(ctx, mode),
+
 			Type:           "file",
 			Name:           name,
 			Content:        "",
@@ -36,23 +66,26 @@ func (i *LemonInode) createFileInode(ctx context.Context, name string, flags uin
 			LastModifiedAt: now,
 		},
 
-		Parent:     i.Content,
-		TargetFile: i.Content.TargetFile,
+		Parent: i.Content,
 	}
 
+	snapshot := i.Content.Snapshot()
 	i.Content.Directory.Content = append(i.Content.Directory.Content, newFile)
-	i.Content.WriteToFile()
-
-	lemonInode := NewLemonInode(&newFile, i.Content)
+	i.Content.Touch()
+	if err := i.Content.Persist(file.CreateChange(&newFile), file.SetattrChange(i.Content)); err != nil {
+		return nil, nil, file.Rollback(err, snapshot)
+	}
 
-	return i.NewInode(ctx, lemonInode, fs.StableAttr{Mode: fuse.S_IFREG}), filehandle.NewLemonFileHandle(&newFile, flags)
+	return i.newChildInode(ctx, &newFile), filehandle.NewLemonFileHandle(&newFile, flags, i.Options.ReadOnly), 0
 }
 
-func (i *LemonInode) createDirectoryInode(ctx context.Context, name string) *fs.Inode {
+func (i *LemonInode) createDirectoryInode(ctx context.Context, name string, mode uint32) (*fs.Inode, syscall.Errno) {
 	now := uint64(time.Now().Unix())
 	newDir := file.LemonDirectoryChild{
 		Type: "directory",
 		Directory: &file.LemonDirectory{
+			LemonPermission: newPermission(ctx, mode),
+
 			Type:           "directory",
 			Name:           name,
 			Content:        []file.LemonDirectoryChild{},
@@ -61,24 +94,73 @@ func (i *LemonInode) createDirectoryInode(ctx context.Context, name string) *fs.
 			CreatedAt:      now,
 		},
 
-		Parent:     i.Content,
-		TargetFile: i.Content.TargetFile,
+		Parent: i.Content,
 	}
 
+	snapshot := i.Content.Snapshot()
 	i.Content.Directory.Content = append(i.Content.Directory.Content, newDir)
-	i.Content.WriteToFile()
+	i.Content.Touch()
+	if err := i.Content.Persist(file.CreateChange(&newDir), file.SetattrChange(i.Content)); err != nil {
+		return nil, file.Rollback(err, snapshot)
+	}
+
+	return i.newChildInode(ctx, &newDir), 0
+}
+
+func (i *LemonInode) createSymlinkInode(ctx context.Context, name string, target string) (*fs.Inode, syscall.Errno) {
+	now := uint64(time.Now().Unix())
+	newSymlink := file.LemonDirectoryChild{
+		Type: "symlink",
+		Symlink: &file.LemonSymlink{
+			LemonPermission: newPermission(ctx, 0777),
+
+			Type:           "symlink",
+			Name:           name,
+			Target:         target,
+			LastAccessedAt: now,
+			LastModifiedAt: now,
+			CreatedAt:      now,
+		},
+
+		Parent: i.Content,
+	}
+
+	snapshot := i.Content.Snapshot()
+	i.Content.Directory.Content = append(i.Content.Directory.Content, newSymlink)
+	i.Content.Touch()
+	if err := i.Content.Persist(file.CreateChange(&newSymlink), file.SetattrChange(i.Content)); err != nil {
+		return nil, file.Rollback(err, snapshot)
+	}
+
+	return i.newChildInode(ctx, &newSymlink), 0
+}
+
+// newChildInode creates the kernel inode of a child node, the options are inherited from i.
+// All links of a file get the same inode number, go-fuse returns the existing kernel inode for it.
+func (i *LemonInode) newChildInode(ctx context.Context, content *file.LemonDirectoryChild) *fs.Inode {
+	lemonInode := NewLemonInode(content, i.Content)
+	lemonInode.Options = i.Options
+	lemonInode.inos = i.inos
+
+	return i.NewInode(ctx, lemonInode, fs.StableAttr{Mode: content.FileType(), Ino: i.ino(content)})
+}
 
-	lemonInode := NewLemonInode(&newDir, i.Content)
+// ino returns the inode number of a child node, it is 0 for nodes which aren't hard linked files
+func (i *LemonInode) ino(content *file.LemonDirectoryChild) uint64 {
+	if !content.IsFile() {
+		return 0
+	}
 
-	return i.NewInode(ctx, lemonInode, fs.StableAttr{Mode: fuse.S_IFDIR})
+	return i.inos.of(content.File.ID)
 }
 
 func NewLemonInode(content *file.LemonDirectoryChild, parent *file.LemonDirectoryChild) *LemonInode {
 	lemonInode := &LemonInode{
 		Content: content,
+		inos:    newInodeNumbers(),
 	}
 
-	lemonInode.Content.ApplyParentAndTarget(parent)
+	lemonInode.Content.ApplyParent(parent)
 
 	return lemonInode
 }
@@ -93,6 +175,18 @@ var _ fs.NodeCreater = (*LemonInode)(nil)
 var _ fs.NodeSetattrer = (*LemonInode)(nil)
 var _ fs.NodeRenamer = (*LemonInode)(nil)
 var _ fs.NodeMkdirer = (*LemonInode)(nil)
+var _ fs.NodeUnlinker = (*LemonInode)(nil)
+var _ fs.NodeRmdirer = (*LemonInode)(nil)
+var _ fs.NodeAccesser = (*LemonInode)(nil)
+var _ fs.NodeSymlinker = (*LemonInode)(nil)
+var _ fs.NodeReadlinker = (*LemonInode)(nil)
+var _ fs.NodeLinker = (*LemonInode)(nil)
+var _ fs.NodeGetxattrer = (*LemonInode)(nil)
+var _ fs.NodeSetxattrer = (*LemonInode)(nil)
+var _ fs.NodeListxattrer = (*LemonInode)(nil)
+var _ fs.NodeRemovexattrer = (*LemonInode)(nil)
+var _ fs.NodeStatfser = (*LemonInode)(nil)
+var _ fs.NodeFsyncer = (*LemonInode)(nil)
 
 func (i *LemonInode) OnAdd(ctx context.Context) {
 	log.Println("OnAdd", i.Content.Path())
@@ -100,15 +194,14 @@ func (i *LemonInode) OnAdd(ctx context.Context) {
 
 func (i *LemonInode) findChild(name string) (file.LemonDirectoryChild, bool) {
 	return lo.Find(i.Content.Directory.Content, func(child file.LemonDirectoryChild) bool {
-		if child.IsFile() && child.File.Name == name {
-			return true
-		}
-
-		if child.IsDirectory() && child.Directory.Name == name {
-			return true
-		}
+		return child.Name() == name
+	})
+}
 
-		return false
+// removeChild removes the child with the given name from the directory content
+func (i *LemonInode) removeChild(name string) {
+	i.Content.Directory.Content = lo.Filter(i.Content.Directory.Content, func(child file.LemonDirectoryChild, _ int) bool {
+		return child.Name() != name
 	})
 }
 
@@ -116,16 +209,22 @@ func (i *LemonInode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno)
 	i.rwLock.RLock()
 	defer i.rwLock.RUnlock()
 
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
 	log.Println("Readdir", i.Content.Path())
 
-	if i.Content.IsFile() {
+	if !i.Content.IsDirectory() {
 		return nil, syscall.ENOTDIR
 	}
 
+	if errno := i.checkAccess(ctx, i.Content, maskRead); errno != 0 {
+		return nil, errno
+	}
+
 	entries := []fuse.DirEntry{}
 	for _, child := range i.Content.Directory.Content {
-		mode := lo.Ternary(child.IsFile(), fuse.S_IFREG, fuse.S_IFDIR)
-		entries = append(entries, fuse.DirEntry{Name: child.Name(), Mode: uint32(mode)})
+		entries = append(entries, fuse.DirEntry{Name: child.Name(), Mode: child.FileType(), Ino: i.ino(&child)})
 	}
 
 	return fs.NewListDirStream(entries), 0
@@ -135,41 +234,38 @@ func (i *LemonInode) Lookup(ctx context.Context, name string, out *fuse.EntryOut
 	i.rwLock.RLock()
 	defer i.rwLock.RUnlock()
 
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
 	log.Printf("Lookup %s in %s", name, i.Content.Path())
 
+	// searching a directory needs the execute permission
+	if errno := i.checkAccess(ctx, i.Content, maskExec); errno != 0 {
+		return nil, errno
+	}
+
 	found, ok := i.findChild(name)
 
 	if !ok {
 		return nil, syscall.ENOENT
 	}
 
-	foundInode := NewLemonInode(&found, i.Content)
-
-	mode := uint32(lo.Ternary(found.IsFile(), fuse.S_IFREG, fuse.S_IFDIR))
+	// the kernel refuses to link an inode reported with 0 links
+	i.fillAttr(&found, &out.Attr)
 
-	return i.NewInode(ctx, foundInode, fs.StableAttr{Mode: mode}), 0
+	return i.newChildInode(ctx, &found), 0
 }
 
 func (i *LemonInode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	i.rwLock.RLock()
 	defer i.rwLock.RUnlock()
 
-	log.Println("Getattr", i.Content.Path())
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
 
-	if i.Content.IsFile() {
-		out.Size = uint64(len(i.Content.File.Content))
-		out.Atime = uint64(i.Content.File.LastAccessedAt)
-		out.Mtime = uint64(i.Content.File.LastModifiedAt)
-		out.Ctime = uint64(i.Content.File.CreatedAt)
-		out.Mode = fuse.S_IFREG
-	}
+	log.Println("Getattr", i.Content.Path())
 
-	if i.Content.IsDirectory() {
-		out.Atime = uint64(i.Content.Directory.LastAccessedAt)
-		out.Mtime = uint64(i.Content.Directory.LastModifiedAt)
-		out.Ctime = uint64(i.Content.Directory.CreatedAt)
-		out.Mode = fuse.S_IFDIR
-	}
+	i.fillAttr(i.Content, &out.Attr)
 
 	return 0
 }
@@ -178,30 +274,66 @@ func (i *LemonInode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uin
 	i.rwLock.RLock()
 	defer i.rwLock.RUnlock()
 
+	// truncating changes the tree
+	if flags&syscall.O_TRUNC == syscall.O_TRUNC {
+		i.Content.LockTree()
+		defer i.Content.UnlockTree()
+	} else {
+		i.Content.RLockTree()
+		defer i.Content.RUnlockTree()
+	}
+
 	log.Printf("Open %s, flags %d, truncate: %t", i.Content.Path(), flags, flags&syscall.O_TRUNC == syscall.O_TRUNC)
 
 	if i.Content.IsDirectory() {
 		return i, 0, syscall.EISDIR
 	}
 
+	// symbolic links are followed by the kernel, they can only be opened with O_NOFOLLOW
+	if i.Content.IsSymlink() {
+		return nil, 0, syscall.ELOOP
+	}
+
+	if i.Options.ReadOnly && openMask(flags)&maskWrite != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, openMask(flags)); errno != 0 {
+		return nil, 0, errno
+	}
+
 	if flags&syscall.O_TRUNC == syscall.O_TRUNC {
-		i.Content.File.Content = ""
-		i.Content.WriteToFile()
+		snapshot := i.Content.Snapshot()
+		i.Content.File.Truncate(0)
+		if err := i.Content.Persist(file.SetattrChange(i.Content)); err != nil {
+			return nil, 0, file.Rollback(err, snapshot)
+		}
 	}
 
-	return filehandle.NewLemonFileHandle(i.Content, flags), 0, 0
+	return filehandle.NewLemonFileHandle(i.Content, flags, i.Options.ReadOnly), 0, 0
 }
 
 func (i *LemonInode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
 	i.rwLock.Lock()
 	defer i.rwLock.Unlock()
 
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
 	log.Printf("Create %s in %s, flags: %d, mode: %d", name, i.Content.Path(), flags, mode)
 
-	if i.Content.IsFile() {
+	if i.Options.ReadOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+
+	if !i.Content.IsDirectory() {
 		return nil, nil, 0, syscall.ENOTDIR
 	}
 
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return nil, nil, 0, errno
+	}
+
 	// check mode
 	if mode&syscall.S_IFMT != syscall.S_IFREG {
 		return nil, nil, 0, syscall.ENOTSUP
@@ -220,12 +352,18 @@ func (i *LemonInode) Create(ctx context.Context, name string, flags uint32, mode
 		}
 
 		// create or open an existing file
+		i.fillAttr(&file, &out.Attr)
+
+		return i.newChildInode(ctx, &file), filehandle.NewLemonFileHandle(&file, flags, i.Options.ReadOnly), 0, 0
+	}
 
-		foundInode := NewLemonInode(&file, i.Content)
-		return i.NewInode(ctx, foundInode, fs.StableAttr{Mode: fuse.S_IFREG}), filehandle.NewLemonFileHandle(&file, flags), 0, 0
+	newFile, newFileHandle, errno := i.createFileInode(ctx, name, flags, mode)
+	if errno != 0 {
+		return nil, nil, 0, errno
 	}
+	// the kernel caches the entry, it must have the mode and the owner of the new file
+	i.fillAttr(newFile.Operations().(*LemonInode).Content, &out.Attr)
 
-	newFile, newFileHandle := i.createFileInode(ctx, name, flags)
 	return newFile, newFileHandle, 0, 0
 }
 
@@ -233,7 +371,34 @@ func (i *LemonInode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.Set
 	i.rwLock.Lock()
 	defer i.rwLock.Unlock()
 
-	log.Printf("Set attr of %s", i.Content.Path())
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	log.Printf("Set attr of %s, valid: %d", i.Content.Path(), in.Valid)
+
+	if i.Options.ReadOnly {
+		return syscall.EROFS
+	}
+
+	if errno := i.checkSetattr(ctx, fh, in); errno != 0 {
+		return errno
+	}
+
+	snapshot := i.Content.Snapshot()
+	if errno := i.Content.SetAttr(in); errno != 0 {
+		snapshot.Restore()
+		return errno
+	}
+	if err := i.Content.Persist(file.SetattrChange(i.Content)); err != nil {
+		return file.Rollback(err, snapshot)
+	}
+
+	// a change through an open file, like ftruncate, is flushed when the file is closed
+	if handle, ok := fh.(*filehandle.LemonFileHandle); ok {
+		handle.MarkDirty()
+	}
+
+	i.fillAttr(i.Content, &out.Attr)
 
 	return 0
 }
@@ -242,7 +407,10 @@ func (i *LemonInode) Rename(ctx context.Context, name string, newParent fs.Inode
 	i.rwLock.Lock()
 	defer i.rwLock.Unlock()
 
-	if i.Content.IsFile() {
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	if !i.Content.IsDirectory() {
 		return syscall.ENOTDIR
 	}
 
@@ -250,9 +418,10 @@ func (i *LemonInode) Rename(ctx context.Context, name string, newParent fs.Inode
 	newChildren := []file.LemonDirectoryChild{}
 	ok := false
 	var source *file.LemonDirectoryChild
-	for _, f := range i.Content.Directory.Content {
+	for index, f := range i.Content.Directory.Content {
 		if f.Name() == name {
-			source = &f
+			// point to the entry in the directory, the name of a hard link is stored in the entry
+			source = &i.Content.Directory.Content[index]
 			ok = true
 			continue
 		}
@@ -271,39 +440,106 @@ func (i *LemonInode) Rename(ctx context.Context, name string, newParent fs.Inode
 
 	log.Printf("rename %s in %s to %s in %s", name, i.Content.Path(), newName, targetParent.Content.Path())
 
+	if i.Options.ReadOnly {
+		return syscall.EROFS
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return errno
+	}
+
+	if errno := i.checkAccess(ctx, targetParent.Content, maskWrite|maskExec); errno != 0 {
+		return errno
+	}
+
 	// no need to move
 	if targetParent.Content.Path() == i.Content.Path() && name == newName {
 		return 0
 	}
 
+	// everything below is rolled back if the change can't be persisted
+	snapshots := []*file.Snapshot{i.Content.Snapshot(), targetParent.Content.Snapshot(), source.Snapshot()}
+
+	oldPath := filepath.Join(i.Content.Path(), name)
+	newPath := filepath.Join(targetParent.Content.Path(), newName)
+
 	existsTarget, ok := targetParent.findChild(newName)
+	if ok {
+		snapshots = append(snapshots, existsTarget.Snapshot())
+	}
+
+	// touchParents sets the timestamps of the directories whose entries are changed, and records them after changes
+	touchParents := func(changes ...*file.Change) []*file.Change {
+		i.Content.Touch()
+		changes = append(changes, file.SetattrChange(i.Content))
+		if targetParent.Content.Directory != i.Content.Directory {
+			targetParent.Content.Touch()
+			changes = append(changes, file.SetattrChange(targetParent.Content))
+		}
+
+		return changes
+	}
 	if !ok {
 		// move directly
 		source.Rename(newName)
 
 		if targetParent.Content.Path() == i.Content.Path() {
-			i.Content.WriteToFile()
+			if err := i.Content.Persist(touchParents(file.RenameChange(oldPath, newPath))...); err != nil {
+				return file.Rollback(err, snapshots...)
+			}
+			i.movedChild(name, source)
 			return 0
 		}
 
+		source.Parent = targetParent.Content
 		targetParent.Content.Directory.Content = append(targetParent.Content.Directory.Content, *source)
 		i.Content.Directory.Content = newChildren
 
-		i.Content.WriteToFile()
+		if err := i.Content.Persist(touchParents(file.RenameChange(oldPath, newPath))...); err != nil {
+			return file.Rollback(err, snapshots...)
+		}
+		i.movedChild(name, source)
 
 		return 0
 	}
 
-	if source.IsFile() {
+	if !source.IsDirectory() {
 		if existsTarget.IsDirectory() {
 			return syscall.EEXIST
 		}
 
+		if source.IsSymlink() || existsTarget.IsSymlink() || source.File.Links() > 1 || existsTarget.File.Links() > 1 {
+			// replace the target with the source, other hard links of the target keep the old content
+			if existsTarget.IsFile() && existsTarget.File.Nlink > 1 {
+				existsTarget.File.Nlink--
+			}
+
+			source.Rename(newName)
+			source.Parent = targetParent.Content
+			i.Content.Directory.Content = newChildren
+			targetParent.removeChild(newName)
+			targetParent.Content.Directory.Content = append(targetParent.Content.Directory.Content, *source)
+
+			if err := i.Content.Persist(touchParents(file.RenameChange(oldPath, newPath))...); err != nil {
+				return file.Rollback(err, snapshots...)
+			}
+			i.movedChild(name, source)
+
+			return 0
+		}
+
 		// overwrite the file
 		existsTarget.File.Content = source.File.Content
 		i.Content.Directory.Content = newChildren
 
-		i.Content.WriteToFile()
+		changes := []*file.Change{
+			file.WriteChange(&existsTarget, 0, []byte(existsTarget.File.Content)),
+			file.SetattrChange(&existsTarget),
+			file.DeleteChange(oldPath),
+		}
+		if err := i.Content.Persist(touchParents(changes...)...); err != nil {
+			return file.Rollback(err, snapshots...)
+		}
 
 		return 0
 	}
@@ -318,26 +554,278 @@ func (i *LemonInode) Rename(ctx context.Context, name string, newParent fs.Inode
 
 	// move
 	source.Rename(newName)
+	source.Parent = targetParent.Content
 	targetParent.Content.Directory.Content = append(targetParent.Content.Directory.Content, *source)
 	i.Content.Directory.Content = newChildren
 
-	i.Content.WriteToFile()
+	if err := i.Content.Persist(touchParents(file.RenameChange(oldPath, newPath))...); err != nil {
+		return file.Rollback(err, snapshots...)
+	}
+	i.movedChild(name, source)
+
 	return 0
 }
 
+// movedChild updates the kernel inode of a renamed child, it holds a copy of the directory entry
+// which must point to the new parent so its path is right
+func (i *LemonInode) movedChild(name string, entry *file.LemonDirectoryChild) {
+	child := i.GetChild(name)
+	if child == nil {
+		return
+	}
+
+	if lemonInode, ok := child.Operations().(*LemonInode); ok {
+		lemonInode.Content.Parent = entry.Parent
+		lemonInode.Content.LinkName = entry.LinkName
+	}
+}
+
 func (i *LemonInode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	i.rwLock.Lock()
 	defer i.rwLock.Unlock()
 
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
 	log.Printf("Mkdir %s in %s", name, i.Content.Path())
 
-	if i.Content.IsFile() {
+	if i.Options.ReadOnly {
+		return nil, syscall.EROFS
+	}
+
+	if !i.Content.IsDirectory() {
 		return nil, syscall.ENOTDIR
 	}
 
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return nil, errno
+	}
+
 	if _, ok := i.findChild(name); ok {
 		return nil, syscall.EEXIST
 	}
 
-	return i.createDirectoryInode(ctx, name), 0
+	newDir, errno := i.createDirectoryInode(ctx, name, mode)
+	if errno != 0 {
+		return nil, errno
+	}
+	i.fillAttr(newDir.Operations().(*LemonInode).Content, &out.Attr)
+
+	return newDir, 0
+}
+
+func (i *LemonInode) Unlink(ctx context.Context, name string) syscall.Errno {
+	i.rwLock.Lock()
+	defer i.rwLock.Unlock()
+
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	log.Printf("Unlink %s in %s", name, i.Content.Path())
+
+	if i.Options.ReadOnly {
+		return syscall.EROFS
+	}
+
+	if !i.Content.IsDirectory() {
+		return syscall.ENOTDIR
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return errno
+	}
+
+	found, ok := i.findChild(name)
+	if !ok {
+		return syscall.ENOENT
+	}
+
+	if found.IsDirectory() {
+		return syscall.EISDIR
+	}
+
+	snapshots := []*file.Snapshot{i.Content.Snapshot(), found.Snapshot()}
+
+	if found.IsFile() && found.File.Nlink > 1 {
+		found.File.Nlink--
+	}
+
+	i.removeChild(name)
+	i.Content.Touch()
+	if err := i.Content.Persist(file.DeleteChange(filepath.Join(i.Content.Path(), name)), file.SetattrChange(i.Content)); err != nil {
+		return file.Rollback(err, snapshots...)
+	}
+
+	// the kernel inode is forgotten by the bridge after we return successfully,
+	// so later lookups will go through Lookup and get ENOENT
+	return 0
+}
+
+func (i *LemonInode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	i.rwLock.Lock()
+	defer i.rwLock.Unlock()
+
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	log.Printf("Rmdir %s in %s", name, i.Content.Path())
+
+	if i.Options.ReadOnly {
+		return syscall.EROFS
+	}
+
+	if !i.Content.IsDirectory() {
+		return syscall.ENOTDIR
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return errno
+	}
+
+	found, ok := i.findChild(name)
+	if !ok {
+		return syscall.ENOENT
+	}
+
+	if !found.IsDirectory() {
+		return syscall.ENOTDIR
+	}
+
+	if len(found.Directory.Content) != 0 {
+		return syscall.ENOTEMPTY
+	}
+
+	snapshot := i.Content.Snapshot()
+	i.removeChild(name)
+	i.Content.Touch()
+	if err := i.Content.Persist(file.DeleteChange(filepath.Join(i.Content.Path(), name)), file.SetattrChange(i.Content)); err != nil {
+		return file.Rollback(err, snapshot)
+	}
+
+	return 0
+}
+
+func (i *LemonInode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	i.rwLock.Lock()
+	defer i.rwLock.Unlock()
+
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	log.Printf("Symlink %s in %s to %s", name, i.Content.Path(), target)
+
+	if i.Options.ReadOnly {
+		return nil, syscall.EROFS
+	}
+
+	if !i.Content.IsDirectory() {
+		return nil, syscall.ENOTDIR
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return nil, errno
+	}
+
+	if _, ok := i.findChild(name); ok {
+		return nil, syscall.EEXIST
+	}
+
+	newSymlink, errno := i.createSymlinkInode(ctx, name, target)
+	if errno != 0 {
+		return nil, errno
+	}
+	i.fillAttr(newSymlink.Operations().(*LemonInode).Content, &out.Attr)
+
+	return newSymlink, 0
+}
+
+func (i *LemonInode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	i.rwLock.RLock()
+	defer i.rwLock.RUnlock()
+
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
+	log.Println("Readlink", i.Content.Path())
+
+	if !i.Content.IsSymlink() {
+		return nil, syscall.EINVAL
+	}
+
+	return []byte(i.Content.Symlink.Target), 0
+}
+
+func (i *LemonInode) Link(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	i.rwLock.Lock()
+	defer i.rwLock.Unlock()
+
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	targetInode, ok := target.(*LemonInode)
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+
+	log.Printf("Link %s in %s to %s", name, i.Content.Path(), targetInode.Content.Path())
+
+	if i.Options.ReadOnly {
+		return nil, syscall.EROFS
+	}
+
+	if !i.Content.IsDirectory() {
+		return nil, syscall.ENOTDIR
+	}
+
+	if !targetInode.Content.IsFile() {
+		return nil, syscall.EPERM
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite|maskExec); errno != 0 {
+		return nil, errno
+	}
+
+	if _, ok := i.findChild(name); ok {
+		return nil, syscall.EEXIST
+	}
+
+	snapshots := []*file.Snapshot{i.Content.Snapshot(), targetInode.Content.Snapshot()}
+
+	sharedFile := targetInode.Content.File
+	if sharedFile.ID == 0 {
+		sharedFile.ID = i.Content.NextFileID()
+	}
+	sharedFile.Nlink = sharedFile.Links() + 1
+
+	newLink := file.LemonDirectoryChild{
+		Type:     "file",
+		File:     sharedFile,
+		LinkName: name,
+
+		Parent: i.Content,
+	}
+
+	i.Content.Directory.Content = append(i.Content.Directory.Content, newLink)
+	i.Content.Touch()
+	changes := []*file.Change{file.SetattrChange(targetInode.Content), file.CreateChange(&newLink), file.SetattrChange(i.Content)}
+	if err := i.Content.Persist(changes...); err != nil {
+		return nil, file.Rollback(err, snapshots...)
+	}
+
+	// the new entry is the kernel inode of the target, lookups of either name return it from now on
+	i.inos.set(sharedFile.ID, targetInode.StableAttr().Ino)
+	i.fillAttr(&newLink, &out.Attr)
+
+	return targetInode.EmbeddedInode(), 0
+}
+
+// Fsync is called for directories and for files without a handle, it writes the pending changes of the whole tree
+func (i *LemonInode) Fsync(ctx context.Context, f fs.FileHandle, flags uint32) syscall.Errno {
+	if fsyncer, ok := f.(fs.FileFsyncer); ok {
+		return fsyncer.Fsync(ctx, flags)
+	}
+
+	log.Println("Fsync", i.Content.Path())
+
+	return file.Errno(i.Content.Flush())
 }
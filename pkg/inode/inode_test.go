@@ -1,16 +1,23 @@
 package inode_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
-	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/lemonnekogh/lemonfs/internal/fusetest"
 	"github.com/lemonnekogh/lemonfs/pkg/file"
 	"github.com/lemonnekogh/lemonfs/pkg/inode"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestMain(m *testing.M) {
@@ -49,16 +56,9 @@ func TestLookup(t *testing.T) {
 			},
 		},
 	}, nil)
-	root.Content.ApplyParentAndTarget(nil)
+	root.Content.ApplyParent(nil)
 
-	tmpDir := t.TempDir()
-	server, err := fs.Mount(tmpDir, root, &fs.Options{
-		MountOptions: fuse.MountOptions{
-			Debug: true,
-		},
-	})
-	r.NoError(err)
-	defer server.Unmount()
+	tmpDir := fusetest.Mount(t, root)
 
 	// fileA
 	stat, err := os.Stat(filepath.Join(tmpDir, "b", "a"))
@@ -112,17 +112,10 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
 		// rename file
-		err = os.Rename(filepath.Join(tmpDir, "b", "a"), filepath.Join(tmpDir, "b", "c"))
+		err := os.Rename(filepath.Join(tmpDir, "b", "a"), filepath.Join(tmpDir, "b", "c"))
 		r.NoError(err)
 		r.Equal("c", fileA.Name)
 
@@ -153,16 +146,9 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		err := os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
 		r.NoError(err)
 		r.Equal("b", dirA.Name)
 
@@ -230,16 +216,9 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Rename(filepath.Join(tmpDir, "c", "a"), filepath.Join(tmpDir, "d", "a"))
+		err := os.Rename(filepath.Join(tmpDir, "c", "a"), filepath.Join(tmpDir, "d", "a"))
 		r.NoError(err)
 		r.Equal("a", fileA.Name)
 
@@ -299,16 +278,9 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Rename(filepath.Join(tmpDir, "c", "a"), filepath.Join(tmpDir, "c", "b"))
+		err := os.Rename(filepath.Join(tmpDir, "c", "a"), filepath.Join(tmpDir, "c", "b"))
 		r.NoError(err)
 		r.Equal("hello", fileB.Content)
 
@@ -331,16 +303,9 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		err := os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
 		r.True(os.IsNotExist(err))
 	})
 
@@ -376,16 +341,9 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		err := os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
 		r.True(os.IsExist(err))
 	})
 
@@ -421,16 +379,9 @@ func TestRename(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		err := os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
 		r.True(os.IsExist(err))
 	})
 }
@@ -455,16 +406,9 @@ func TestReaddir(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		_, err = os.ReadDir(filepath.Join(tmpDir, "a"))
+		_, err := os.ReadDir(filepath.Join(tmpDir, "a"))
 		r.Error(err)
 		r.ErrorIs(err, syscall.ENOTDIR)
 	})
@@ -507,14 +451,7 @@ func TestReaddir(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
 		entries, err := os.ReadDir(filepath.Join(tmpDir, "a"))
 		r.NoError(err)
@@ -547,16 +484,9 @@ func TestMkdir(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Mkdir(filepath.Join(tmpDir, "a", "b"), 0755)
+		err := os.Mkdir(filepath.Join(tmpDir, "a", "b"), 0755)
 		r.ErrorIs(err, syscall.ENOTDIR)
 	})
 
@@ -579,16 +509,9 @@ func TestMkdir(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Mkdir(filepath.Join(tmpDir, "a"), 0755)
+		err := os.Mkdir(filepath.Join(tmpDir, "a"), 0755)
 		r.ErrorIs(err, syscall.EEXIST)
 	})
 
@@ -611,16 +534,9 @@ func TestMkdir(t *testing.T) {
 			},
 		}, nil)
 
-		tmpDir := t.TempDir()
-		server, err := fs.Mount(tmpDir, root, &fs.Options{
-			MountOptions: fuse.MountOptions{
-				Debug: true,
-			},
-		})
-		r.NoError(err)
-		defer server.Unmount()
+		tmpDir := fusetest.Mount(t, root)
 
-		err = os.Mkdir(filepath.Join(tmpDir, "a", "b"), 0755)
+		err := os.Mkdir(filepath.Join(tmpDir, "a", "b"), 0755)
 		r.NoError(err)
 
 		dir, err := os.Stat(filepath.Join(tmpDir, "a", "b"))
@@ -629,6 +545,52 @@ func TestMkdir(t *testing.T) {
 	})
 }
 
+func TestEntryTimes(t *testing.T) {
+	r := require.New(t)
+
+	dirA := &file.LemonDirectory{Type: "directory", Name: "a", Content: []file.LemonDirectoryChild{}, CreatedAt: 1000, LastModifiedAt: 1000}
+	dirB := &file.LemonDirectory{Type: "directory", Name: "b", Content: []file.LemonDirectoryChild{}, CreatedAt: 1000, LastModifiedAt: 1000}
+
+	root := inode.NewLemonInode(&file.LemonDirectoryChild{
+		Type: "directory",
+		Directory: &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "directory", Directory: dirA},
+				{Type: "directory", Directory: dirB},
+			},
+		},
+	}, nil)
+
+	tmpDir := fusetest.Mount(t, root)
+
+	pathA, pathB := filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b")
+
+	// the modification and change time of the directories whose entries change advance
+	for _, change := range []func() error{
+		func() error { return os.WriteFile(filepath.Join(pathA, "f"), nil, 0644) },
+		func() error { return os.WriteFile(filepath.Join(pathA, "g"), nil, 0644) },
+		func() error { return os.Mkdir(filepath.Join(pathA, "d"), 0755) },
+		func() error { return os.Symlink("f", filepath.Join(pathA, "l")) },
+		func() error { return os.Link(filepath.Join(pathA, "f"), filepath.Join(pathA, "k")) },
+		func() error { return os.Rename(filepath.Join(pathA, "g"), filepath.Join(pathA, "h")) },
+		func() error { return os.Remove(filepath.Join(pathA, "h")) },
+		func() error { return os.Remove(filepath.Join(pathA, "d")) },
+	} {
+		dirA.LastModifiedAt, dirA.CreatedAt = 1000, 1000
+		r.NoError(change())
+		r.Greater(dirA.LastModifiedAt, uint64(1000))
+		r.Greater(dirA.CreatedAt, uint64(1000))
+	}
+
+	// both directories of a move
+	dirA.LastModifiedAt, dirB.LastModifiedAt = 1000, 1000
+	r.NoError(os.Rename(filepath.Join(pathA, "f"), filepath.Join(pathB, "f")))
+	r.Greater(dirA.LastModifiedAt, uint64(1000))
+	r.Greater(dirB.LastModifiedAt, uint64(1000))
+}
+
 func TestTruncate(t *testing.T) {
 	r := require.New(t)
 
@@ -649,14 +611,7 @@ func TestTruncate(t *testing.T) {
 		},
 	}, nil)
 
-	tmpDir := t.TempDir()
-	server, err := fs.Mount(tmpDir, root, &fs.Options{
-		MountOptions: fuse.MountOptions{
-			Debug: true,
-		},
-	})
-	r.NoError(err)
-	defer server.Unmount()
+	tmpDir := fusetest.Mount(t, root)
 
 	f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_TRUNC, 0644)
 	r.NoError(err)
@@ -664,3 +619,1906 @@ func TestTruncate(t *testing.T) {
 
 	r.Equal("", fileA.Content)
 }
+
+func TestUnlink(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello",
+		}
+
+		fileB := &file.LemonFile{
+			Type: "file",
+			Name: "b",
+		}
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: fileA},
+				{Type: "file", File: fileB},
+			},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		// make the kernel know the file first
+		_, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+
+		err = os.Remove(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(1, len(rootDir.Content))
+		r.Equal("b", rootDir.Content[0].Name())
+
+		_, err = os.Stat(filepath.Join(tmpDir, "a"))
+		r.True(os.IsNotExist(err), "should not exist")
+
+		_, err = os.Stat(filepath.Join(tmpDir, "b"))
+		r.NoError(err, "should not be affected")
+	})
+
+	t.Run("not exists", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := syscall.Unlink(filepath.Join(tmpDir, "a"))
+		r.ErrorIs(err, syscall.ENOENT)
+	})
+
+	t.Run("is directory", func(t *testing.T) {
+		r := require.New(t)
+
+		dirA := &file.LemonDirectory{
+			Type:    "directory",
+			Name:    "a",
+			Content: []file.LemonDirectoryChild{},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "directory", Directory: dirA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := syscall.Unlink(filepath.Join(tmpDir, "a"))
+		r.ErrorIs(err, syscall.EISDIR)
+	})
+}
+
+func TestRmdir(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		r := require.New(t)
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{
+					Type: "directory",
+					Directory: &file.LemonDirectory{
+						Type:    "directory",
+						Name:    "a",
+						Content: []file.LemonDirectoryChild{},
+					},
+				},
+			},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Remove(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(0, len(rootDir.Content))
+
+		_, err = os.Stat(filepath.Join(tmpDir, "a"))
+		r.True(os.IsNotExist(err), "should not exist")
+	})
+
+	t.Run("not empty", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{
+						Type: "directory",
+						Directory: &file.LemonDirectory{
+							Type: "directory",
+							Name: "a",
+							Content: []file.LemonDirectoryChild{
+								{Type: "file", File: &file.LemonFile{Type: "file", Name: "b"}},
+							},
+						},
+					},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := syscall.Rmdir(filepath.Join(tmpDir, "a"))
+		r.ErrorIs(err, syscall.ENOTEMPTY)
+	})
+
+	t.Run("is file", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: &file.LemonFile{Type: "file", Name: "a"}},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := syscall.Rmdir(filepath.Join(tmpDir, "a"))
+		r.ErrorIs(err, syscall.ENOTDIR)
+	})
+}
+
+func TestSetattrSize(t *testing.T) {
+	t.Run("shrink", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello world",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Truncate(filepath.Join(tmpDir, "a"), 5)
+		r.NoError(err)
+		r.Equal("hello", fileA.Content)
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(int64(5), stat.Size())
+	})
+
+	t.Run("grow", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Truncate(filepath.Join(tmpDir, "a"), 8)
+		r.NoError(err)
+		r.Equal("hello\x00\x00\x00", fileA.Content)
+	})
+
+	t.Run("by file handle", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello world",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY, 0644)
+		r.NoError(err)
+		defer f.Close()
+
+		err = f.Truncate(2)
+		r.NoError(err)
+		r.Equal("he", fileA.Content)
+
+		stat, err := f.Stat()
+		r.NoError(err)
+		r.Equal(int64(2), stat.Size())
+	})
+
+	t.Run("is directory", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "a"}},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Truncate(filepath.Join(tmpDir, "a"), 0)
+		r.ErrorIs(err, syscall.EISDIR)
+	})
+}
+
+func TestSetattrTimes(t *testing.T) {
+	t.Run("chmod keeps timestamps", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:           "file",
+			Name:           "a",
+			CreatedAt:      100,
+			LastAccessedAt: 200,
+			LastModifiedAt: 300,
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Chmod(filepath.Join(tmpDir, "a"), 0600)
+		r.NoError(err)
+		r.Equal(uint64(100), fileA.CreatedAt)
+		r.Equal(uint64(200), fileA.LastAccessedAt)
+		r.Equal(uint64(300), fileA.LastModifiedAt)
+	})
+
+	t.Run("set mtime only", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:           "file",
+			Name:           "a",
+			LastAccessedAt: 200,
+			LastModifiedAt: 300,
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		// UTIME_OMIT, like touch -m -d
+		const utimeOmit = (1 << 30) - 2
+		err := syscall.UtimesNano(filepath.Join(tmpDir, "a"), []syscall.Timespec{
+			{Nsec: utimeOmit},
+			{Sec: 1000},
+		})
+		r.NoError(err)
+		r.Equal(uint64(200), fileA.LastAccessedAt)
+		r.Equal(uint64(1000), fileA.LastModifiedAt)
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(int64(1000), stat.ModTime().Unix())
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		r := require.New(t)
+
+		dirA := &file.LemonDirectory{
+			Type: "directory",
+			Name: "a",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "directory", Directory: dirA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Chtimes(filepath.Join(tmpDir, "a"), time.Unix(1000, 0), time.Unix(2000, 0))
+		r.NoError(err)
+		r.Equal(uint64(1000), dirA.LastAccessedAt)
+		r.Equal(uint64(2000), dirA.LastModifiedAt)
+	})
+}
+
+func TestPermission(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: &file.LemonFile{Type: "file", Name: "a"}},
+					{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "b"}},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(os.FileMode(0644), stat.Mode().Perm())
+		r.Equal(uint32(os.Getuid()), stat.Sys().(*syscall.Stat_t).Uid)
+
+		stat, err = os.Stat(filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		r.Equal(os.FileMode(0755), stat.Mode().Perm())
+	})
+
+	t.Run("create with mode", func(t *testing.T) {
+		r := require.New(t)
+
+		rootDir := &file.LemonDirectory{
+			Name:    "root",
+			Type:    "directory",
+			Content: []file.LemonDirectoryChild{},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		oldMask := syscall.Umask(0)
+		defer syscall.Umask(oldMask)
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_CREATE|os.O_WRONLY, 0600)
+		r.NoError(err)
+		r.NoError(f.Close())
+
+		err = os.Mkdir(filepath.Join(tmpDir, "b"), 0700)
+		r.NoError(err)
+
+		r.Equal(2, len(rootDir.Content))
+		r.Equal(uint32(0600), rootDir.Content[0].File.Perm(0))
+		r.Equal(uint32(0700), rootDir.Content[1].Directory.Perm(0))
+
+		uid, gid := rootDir.Content[0].File.Owner()
+		r.Equal(uint32(os.Getuid()), uid)
+		r.Equal(uint32(os.Getgid()), gid)
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(os.FileMode(0600), stat.Mode().Perm())
+	})
+
+	t.Run("attributes of new entries", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}, nil)
+
+		// the kernel uses the entries returned by create and mkdir until they time out
+		timeout := time.Minute
+		tmpDir, _ := fusetest.MountServer(t, root, &fs.Options{
+			EntryTimeout: &timeout,
+			AttrTimeout:  &timeout,
+		})
+
+		oldMask := syscall.Umask(0)
+		defer syscall.Umask(oldMask)
+
+		fd, err := syscall.Open(filepath.Join(tmpDir, "a"), syscall.O_CREAT|syscall.O_WRONLY, 0640)
+		r.NoError(err)
+		r.NoError(syscall.Close(fd))
+
+		r.NoError(syscall.Mkdir(filepath.Join(tmpDir, "b"), 0700))
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(os.FileMode(0640), stat.Mode().Perm())
+		r.Equal(uint32(os.Getuid()), stat.Sys().(*syscall.Stat_t).Uid)
+		r.Equal(uint32(os.Getgid()), stat.Sys().(*syscall.Stat_t).Gid)
+
+		stat, err = os.Stat(filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		r.Equal(os.FileMode(0700), stat.Mode().Perm())
+		r.True(stat.IsDir())
+	})
+
+	t.Run("chmod and chown", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type: "file",
+			Name: "a",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Chmod(filepath.Join(tmpDir, "a"), 0600)
+		r.NoError(err)
+		r.Equal(uint32(0600), fileA.Perm(0))
+
+		// changing the group to one we are in is allowed for everyone
+		err = os.Chown(filepath.Join(tmpDir, "a"), -1, os.Getgid())
+		r.NoError(err)
+		_, gid := fileA.Owner()
+		r.Equal(uint32(os.Getgid()), gid)
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(os.FileMode(0600), stat.Mode().Perm())
+	})
+}
+
+// asUser runs fn with the file system uid and gid of the current thread changed, FUSE sees them as the caller
+func asUser(t *testing.T, uid, gid int, fn func()) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the file system uid requires root")
+	}
+
+	// the parent directory shared by t.TempDir() calls has mode 0700, open it so that the user can reach the mount point
+	for dir := t.TempDir(); dir != os.TempDir(); dir = filepath.Dir(dir) {
+		os.Chmod(dir, 0755)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	syscall.Setfsgid(gid)
+	syscall.Setfsuid(uid)
+	defer func() {
+		syscall.Setfsuid(0)
+		syscall.Setfsgid(0)
+	}()
+
+	fn()
+}
+
+func TestAccess(t *testing.T) {
+	rootOwned := file.NewLemonPermission(0700, 0, 0)
+
+	newRoot := func() *inode.LemonInode {
+		return inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				LemonPermission: file.NewLemonPermission(0755, 0, 0),
+
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: &file.LemonFile{LemonPermission: rootOwned, Type: "file", Name: "a"}},
+					{Type: "directory", Directory: &file.LemonDirectory{LemonPermission: rootOwned, Type: "directory", Name: "b"}},
+				},
+			},
+		}, nil)
+	}
+
+	t.Run("denied", func(t *testing.T) {
+		r := require.New(t)
+
+		tmpDir := fusetest.Mount(t, newRoot())
+
+		asUser(t, 1000, 1000, func() {
+			_, err := os.ReadFile(filepath.Join(tmpDir, "a"))
+			r.ErrorIs(err, syscall.EACCES)
+
+			_, err = os.ReadDir(filepath.Join(tmpDir, "b"))
+			r.ErrorIs(err, syscall.EACCES)
+
+			err = os.Mkdir(filepath.Join(tmpDir, "c"), 0755)
+			r.ErrorIs(err, syscall.EACCES)
+
+			err = os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "d"))
+			r.ErrorIs(err, syscall.EACCES)
+		})
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		r := require.New(t)
+
+		tmpDir := fusetest.Mount(t, newRoot())
+
+		// root owns everything
+		err := syscall.Access(filepath.Join(tmpDir, "a"), 4|2)
+		r.NoError(err)
+
+		_, err = os.ReadFile(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+
+		_, err = os.ReadDir(filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+	})
+
+	t.Run("default permissions", func(t *testing.T) {
+		r := require.New(t)
+
+		root := newRoot()
+		root.Options.DefaultPermissions = true
+
+		tmpDir := fusetest.Mount(t, root)
+
+		// the kernel doesn't check permissions without the mount option, lemonfs leaves it to the kernel
+		asUser(t, 1000, 1000, func() {
+			_, err := os.ReadFile(filepath.Join(tmpDir, "a"))
+			r.NoError(err)
+		})
+	})
+}
+
+func TestSetattrPermission(t *testing.T) {
+	newRoot := func() (*inode.LemonInode, *file.LemonDirectory) {
+		rootDir := &file.LemonDirectory{
+			LemonPermission: file.NewLemonPermission(0777, 0, 0),
+
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: &file.LemonFile{LemonPermission: file.NewLemonPermission(0644, 0, 0), Type: "file", Name: "root"}},
+				{Type: "file", File: &file.LemonFile{LemonPermission: file.NewLemonPermission(0666, 0, 0), Type: "file", Name: "shared"}},
+				{Type: "file", File: &file.LemonFile{LemonPermission: file.NewLemonPermission(0444, 1000, 1000), Type: "file", Name: "user", Content: "hello"}},
+				{Type: "directory", Directory: &file.LemonDirectory{
+					LemonPermission: file.NewLemonPermission(0700, 0, 0),
+					Type:            "directory",
+					Name:            "private",
+					Content: []file.LemonDirectoryChild{
+						{Type: "file", File: &file.LemonFile{Type: "file", Name: "a"}},
+					},
+				}},
+			},
+		}
+
+		return inode.NewLemonInode(&file.LemonDirectoryChild{Type: "directory", Directory: rootDir}, nil), rootDir
+	}
+
+	now := []unix.Timespec{{Nsec: unix.UTIME_NOW}, {Nsec: unix.UTIME_NOW}}
+
+	t.Run("not the owner", func(t *testing.T) {
+		r := require.New(t)
+
+		root, rootDir := newRoot()
+		tmpDir := fusetest.Mount(t, root)
+
+		asUser(t, 1000, 1000, func() {
+			r.ErrorIs(os.Chmod(filepath.Join(tmpDir, "root"), 0666), syscall.EPERM)
+			r.ErrorIs(os.Chown(filepath.Join(tmpDir, "root"), 1000, -1), syscall.EPERM)
+			r.ErrorIs(os.Chown(filepath.Join(tmpDir, "root"), -1, 1000), syscall.EPERM)
+			r.ErrorIs(os.Truncate(filepath.Join(tmpDir, "root"), 0), syscall.EACCES)
+			r.ErrorIs(os.Chtimes(filepath.Join(tmpDir, "root"), time.Unix(1, 0), time.Unix(1, 0)), syscall.EPERM)
+			r.ErrorIs(unix.UtimesNanoAt(unix.AT_FDCWD, filepath.Join(tmpDir, "root"), now, 0), syscall.EACCES)
+
+			// writing is enough to truncate and to touch
+			r.NoError(os.Truncate(filepath.Join(tmpDir, "shared"), 0))
+			r.NoError(unix.UtimesNanoAt(unix.AT_FDCWD, filepath.Join(tmpDir, "shared"), now, 0))
+			r.ErrorIs(os.Chtimes(filepath.Join(tmpDir, "shared"), time.Unix(1, 0), time.Unix(1, 0)), syscall.EPERM)
+
+			// searching a directory needs the execute permission
+			_, err := os.Stat(filepath.Join(tmpDir, "private", "a"))
+			r.ErrorIs(err, syscall.EACCES)
+		})
+
+		r.Equal(uint32(0644), rootDir.Content[0].File.Perm(0))
+		uid, gid := rootDir.Content[0].File.Owner()
+		r.Equal(uint32(0), uid)
+		r.Equal(uint32(0), gid)
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		r := require.New(t)
+
+		root, rootDir := newRoot()
+		tmpDir := fusetest.Mount(t, root)
+
+		asUser(t, 1000, 1000, func() {
+			r.NoError(os.Chmod(filepath.Join(tmpDir, "user"), 0600))
+			r.NoError(os.Chtimes(filepath.Join(tmpDir, "user"), time.Unix(1, 0), time.Unix(2, 0)))
+			r.NoError(os.Chown(filepath.Join(tmpDir, "user"), -1, 1000))
+
+			// only root can give a file away, or change the group to one the owner is not in
+			r.ErrorIs(os.Chown(filepath.Join(tmpDir, "user"), 0, -1), syscall.EPERM)
+			r.ErrorIs(os.Chown(filepath.Join(tmpDir, "user"), -1, 0), syscall.EPERM)
+		})
+
+		userFile := rootDir.Content[2].File
+		r.Equal(uint32(0600), userFile.Perm(0))
+		r.Equal(uint64(2), userFile.LastModifiedAt)
+		uid, gid := userFile.Owner()
+		r.Equal(uint32(1000), uid)
+		r.Equal(uint32(1000), gid)
+	})
+
+	t.Run("truncate through a handle", func(t *testing.T) {
+		r := require.New(t)
+
+		root, rootDir := newRoot()
+		tmpDir := fusetest.Mount(t, root)
+
+		asUser(t, 1000, 1000, func() {
+			r.NoError(os.Chmod(filepath.Join(tmpDir, "user"), 0644))
+			f, err := os.OpenFile(filepath.Join(tmpDir, "user"), os.O_WRONLY, 0)
+			r.NoError(err)
+			defer f.Close()
+			r.NoError(os.Chmod(filepath.Join(tmpDir, "user"), 0444))
+
+			// the file is open for writing, like ftruncate(2) it doesn't need the write permission anymore
+			r.NoError(f.Truncate(2))
+			r.ErrorIs(os.Truncate(filepath.Join(tmpDir, "user"), 0), syscall.EACCES)
+		})
+
+		r.Equal("he", rootDir.Content[2].File.Content)
+	})
+}
+
+func TestSymlink(t *testing.T) {
+	t.Run("create and read", func(t *testing.T) {
+		r := require.New(t)
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: &file.LemonFile{Type: "file", Name: "a", Content: "hello"}},
+			},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Symlink("a", filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		r.Equal(2, len(rootDir.Content))
+		r.True(rootDir.Content[1].IsSymlink())
+		r.Equal("a", rootDir.Content[1].Symlink.Target)
+
+		target, err := os.Readlink(filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		r.Equal("a", target)
+
+		stat, err := os.Lstat(filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		r.Equal(os.ModeSymlink, stat.Mode().Type())
+
+		// relative to the directory of the link
+		content, err := os.ReadFile(filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		r.Equal("hello", string(content))
+
+		entries, err := os.ReadDir(tmpDir)
+		r.NoError(err)
+		r.Equal(os.ModeSymlink, entries[1].Type())
+
+		err = os.Symlink("a", filepath.Join(tmpDir, "b"))
+		r.ErrorIs(err, syscall.EEXIST)
+	})
+
+	t.Run("rename and unlink", func(t *testing.T) {
+		r := require.New(t)
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: &file.LemonFile{Type: "file", Name: "a"}},
+				{Type: "symlink", Symlink: &file.LemonSymlink{Type: "symlink", Name: "b", Target: "a"}},
+			},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		// replace the file with the symbolic link
+		err := os.Rename(filepath.Join(tmpDir, "b"), filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(1, len(rootDir.Content))
+		r.True(rootDir.Content[0].IsSymlink())
+		r.Equal("a", rootDir.Content[0].Name())
+
+		err = os.Remove(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(0, len(rootDir.Content))
+	})
+
+	t.Run("readlink on file", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: &file.LemonFile{Type: "file", Name: "a"}},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		_, err := os.Readlink(filepath.Join(tmpDir, "a"))
+		r.ErrorIs(err, syscall.EINVAL)
+	})
+}
+
+func TestLink(t *testing.T) {
+	t.Run("shared content", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type:    "file",
+			Name:    "a",
+			Content: "hello",
+		}
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: fileA},
+				{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "b", Content: []file.LemonDirectoryChild{}}},
+			},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Link(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b", "c"))
+		r.NoError(err)
+		r.NotZero(fileA.ID)
+		r.Equal(uint32(2), fileA.Nlink)
+
+		stat, err := os.Stat(filepath.Join(tmpDir, "b", "c"))
+		r.NoError(err)
+		r.Equal(uint64(2), stat.Sys().(*syscall.Stat_t).Nlink)
+
+		err = os.WriteFile(filepath.Join(tmpDir, "b", "c"), []byte("world"), 0644)
+		r.NoError(err)
+		r.Equal("world", fileA.Content)
+
+		content, err := os.ReadFile(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal("world", string(content))
+
+		err = os.Remove(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		r.Equal(uint32(1), fileA.Nlink)
+
+		content, err = os.ReadFile(filepath.Join(tmpDir, "b", "c"))
+		r.NoError(err)
+		r.Equal("world", string(content))
+	})
+
+	t.Run("json round trip", func(t *testing.T) {
+		r := require.New(t)
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: &file.LemonFile{Type: "file", Name: "a", Content: "hello"}},
+			},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Link(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+
+		jsonContent, err := json.Marshal(root.Content)
+		r.NoError(err)
+		// the content of the hard links is stored once
+		r.Equal(1, strings.Count(string(jsonContent), "hello"))
+
+		loaded := &file.LemonDirectoryChild{}
+		err = json.Unmarshal(jsonContent, loaded)
+		r.NoError(err)
+		loaded.ResolveHardLinks()
+
+		r.Equal(2, len(loaded.Directory.Content))
+		r.Equal("a", loaded.Directory.Content[0].Name())
+		r.Equal("b", loaded.Directory.Content[1].Name())
+		r.Same(loaded.Directory.Content[0].File, loaded.Directory.Content[1].File)
+		r.Equal(uint32(2), loaded.Directory.Content[0].File.Nlink)
+		r.Equal("hello", loaded.Directory.Content[1].File.Content)
+	})
+
+	t.Run("same inode", func(t *testing.T) {
+		r := require.New(t)
+
+		sameInode := func(tmpDir string, names ...string) {
+			stat, err := os.Stat(filepath.Join(tmpDir, names[0]))
+			r.NoError(err)
+
+			for _, name := range names[1:] {
+				linkStat, err := os.Stat(filepath.Join(tmpDir, name))
+				r.NoError(err)
+				r.Equal(stat.Sys().(*syscall.Stat_t).Ino, linkStat.Sys().(*syscall.Stat_t).Ino, name)
+				r.True(os.SameFile(stat, linkStat), name)
+			}
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: &file.LemonFile{Type: "file", Name: "a", Content: "hello"}},
+					{Type: "file", File: &file.LemonFile{Type: "file", Name: "other"}},
+				},
+			},
+		}, nil)
+
+		tmpDir, server := fusetest.MountServer(t, root, nil)
+
+		_, err := os.Stat(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		err = os.Link(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		r.NoError(err)
+		err = os.Link(filepath.Join(tmpDir, "b"), filepath.Join(tmpDir, "c"))
+		r.NoError(err)
+		sameInode(tmpDir, "a", "b", "c")
+
+		entries, err := os.ReadDir(tmpDir)
+		r.NoError(err)
+		inos := map[string]uint64{}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			r.NoError(err)
+			inos[entry.Name()] = info.Sys().(*syscall.Stat_t).Ino
+		}
+		r.Equal(inos["a"], inos["c"])
+		r.NotEqual(inos["a"], inos["other"])
+
+		err = server.Unmount()
+		r.NoError(err)
+
+		// the links of a loaded tree are one inode before any of them is linked again
+		jsonContent, err := json.Marshal(root.Content)
+		r.NoError(err)
+
+		loaded := &file.LemonDirectoryChild{}
+		err = json.Unmarshal(jsonContent, loaded)
+		r.NoError(err)
+		loaded.ResolveHardLinks()
+
+		tmpDir = fusetest.Mount(t, inode.NewLemonInode(loaded, nil))
+
+		sameInode(tmpDir, "c", "b", "a")
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		r := require.New(t)
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "a"}},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Link(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b"))
+		r.ErrorIs(err, syscall.EPERM)
+	})
+}
+
+func TestXattr(t *testing.T) {
+	t.Run("set, get, list and remove", func(t *testing.T) {
+		r := require.New(t)
+
+		fileA := &file.LemonFile{
+			Type: "file",
+			Name: "a",
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: fileA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		path := filepath.Join(tmpDir, "a")
+		value := []byte{0xff, 0x00, 0xfe, 'l', 'e', 'm', 'o', 'n'}
+
+		err := syscall.Setxattr(path, "user.tag", value, 0)
+		r.NoError(err)
+		r.Equal(value, fileA.Xattrs["user.tag"])
+
+		err = syscall.Setxattr(path, "user.color", []byte("yellow"), 0)
+		r.NoError(err)
+
+		// query the size
+		size, err := syscall.Getxattr(path, "user.tag", nil)
+		r.NoError(err)
+		r.Equal(len(value), size)
+
+		dest := make([]byte, size)
+		_, err = syscall.Getxattr(path, "user.tag", dest)
+		r.NoError(err)
+		r.Equal(value, dest)
+
+		_, err = syscall.Getxattr(path, "user.tag", make([]byte, 2))
+		r.ErrorIs(err, syscall.ERANGE)
+
+		_, err = syscall.Getxattr(path, "user.missing", make([]byte, 16))
+		r.ErrorIs(err, syscall.ENODATA)
+
+		size, err = syscall.Listxattr(path, nil)
+		r.NoError(err)
+		names := make([]byte, size)
+		_, err = syscall.Listxattr(path, names)
+		r.NoError(err)
+		r.Equal("user.color\x00user.tag\x00", string(names))
+
+		err = syscall.Removexattr(path, "user.color")
+		r.NoError(err)
+		r.NotContains(fileA.Xattrs, "user.color")
+
+		err = syscall.Removexattr(path, "user.color")
+		r.ErrorIs(err, syscall.ENODATA)
+	})
+
+	t.Run("create and replace flags", func(t *testing.T) {
+		r := require.New(t)
+
+		dirA := &file.LemonDirectory{
+			Type:   "directory",
+			Name:   "a",
+			Xattrs: map[string][]byte{"user.tag": []byte("old")},
+		}
+
+		root := inode.NewLemonInode(&file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "directory", Directory: dirA},
+				},
+			},
+		}, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		path := filepath.Join(tmpDir, "a")
+
+		// XATTR_CREATE
+		err := syscall.Setxattr(path, "user.tag", []byte("new"), 1)
+		r.ErrorIs(err, syscall.EEXIST)
+
+		// XATTR_REPLACE
+		err = syscall.Setxattr(path, "user.other", []byte("new"), 2)
+		r.ErrorIs(err, syscall.ENODATA)
+
+		err = syscall.Setxattr(path, "user.tag", []byte("new"), 2)
+		r.NoError(err)
+		r.Equal([]byte("new"), dirA.Xattrs["user.tag"])
+	})
+
+	t.Run("json round trip", func(t *testing.T) {
+		r := require.New(t)
+
+		root := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name: "root",
+				Type: "directory",
+				Content: []file.LemonDirectoryChild{
+					{Type: "file", File: &file.LemonFile{Type: "file", Name: "a", Xattrs: map[string][]byte{"user.tag": {0xff, 0x00}}}},
+				},
+			},
+		}
+
+		jsonContent, err := json.Marshal(root)
+		r.NoError(err)
+
+		loaded := &file.LemonDirectoryChild{}
+		err = json.Unmarshal(jsonContent, loaded)
+		r.NoError(err)
+
+		value, ok := loaded.Directory.Content[0].GetXattr("user.tag")
+		r.True(ok)
+		r.Equal([]byte{0xff, 0x00}, value)
+	})
+}
+
+func TestStatfs(t *testing.T) {
+	r := require.New(t)
+
+	rootContent := &file.LemonDirectoryChild{
+		Type: "directory",
+		Directory: &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: &file.LemonFile{Type: "file", Name: "a", Content: strings.Repeat("a", 10000)}},
+				{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "b"}},
+			},
+		},
+	}
+
+	root := inode.NewLemonInode(rootContent, nil)
+	root.Options.Capacity = 1 << 20
+
+	tmpDir := fusetest.Mount(t, root)
+
+	jsonContent, err := json.Marshal(rootContent)
+	r.NoError(err)
+	usedBlocks := uint64((len(jsonContent) + 4095) / 4096)
+
+	stat := syscall.Statfs_t{}
+	err = syscall.Statfs(tmpDir, &stat)
+	r.NoError(err)
+	r.Equal(int64(4096), stat.Bsize)
+	r.Equal(uint64(256), stat.Blocks)
+	r.Equal(256-usedBlocks, stat.Bfree)
+	r.Equal(256-usedBlocks, stat.Bavail)
+	r.Equal(uint64(3)+stat.Ffree, stat.Files)
+	r.Equal(int64(255), stat.Namelen)
+}
+
+func TestStatfsStoredSize(t *testing.T) {
+	r := require.New(t)
+
+	targetFile := filepath.Join(t.TempDir(), "root.json")
+	rootContent := &file.LemonDirectoryChild{
+		Type: "directory",
+		Directory: &file.LemonDirectory{
+			Name:    "root",
+			Type:    "directory",
+			Content: []file.LemonDirectoryChild{},
+		},
+	}
+
+	// the size of the file as it is stored, not of the tree serialized again
+	jsonContent, err := json.Marshal(rootContent)
+	r.NoError(err)
+	err = os.WriteFile(targetFile, append(jsonContent, strings.Repeat(" ", 10000)...), 0644)
+	r.NoError(err)
+
+	loaded, err := file.NewJSONStorage(targetFile).Load()
+	r.NoError(err)
+
+	root := inode.NewLemonInode(loaded, nil)
+	root.Options.Capacity = 1 << 20
+
+	tmpDir := fusetest.Mount(t, root)
+
+	usedBlocks := uint64((len(jsonContent) + 10000 + 4095) / 4096)
+
+	stat := syscall.Statfs_t{}
+	err = syscall.Statfs(tmpDir, &stat)
+	r.NoError(err)
+	r.Equal(256-usedBlocks, stat.Bfree)
+}
+
+func TestWriteToFile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		r := require.New(t)
+
+		targetDir := t.TempDir()
+		targetFile := filepath.Join(targetDir, "lemonfs.json")
+
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}
+		rootChild.SetStorage(file.NewJSONStorage(targetFile))
+		root := inode.NewLemonInode(rootChild, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Mkdir(filepath.Join(tmpDir, "a"), 0755)
+		r.NoError(err)
+
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+
+		loaded := &file.LemonDirectoryChild{}
+		err = json.Unmarshal(jsonContent, loaded)
+		r.NoError(err)
+		r.Equal(1, len(loaded.Directory.Content))
+		r.Equal("a", loaded.Directory.Content[0].Name())
+
+		// no temporary file is left behind
+		entries, err := os.ReadDir(targetDir)
+		r.NoError(err)
+		r.Equal(1, len(entries))
+	})
+
+	t.Run("concurrent changes", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}
+		rootChild.SetStorage(file.NewJSONStorage(targetFile))
+		root := inode.NewLemonInode(rootChild, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		// every change is in the file written last, whichever operation persisted it
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 8)
+		for n := 0; n < 8; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				dir := filepath.Join(tmpDir, fmt.Sprintf("dir-%d", n))
+				if err := os.Mkdir(dir, 0755); err != nil {
+					errs <- err
+					return
+				}
+				for m := 0; m < 5; m++ {
+					if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d", m)), []byte("content"), 0644); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			r.NoError(err)
+		}
+
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+
+		loaded := &file.LemonDirectoryChild{}
+		r.NoError(json.Unmarshal(jsonContent, loaded))
+		r.Equal(8, len(loaded.Directory.Content))
+		for _, dir := range loaded.Directory.Content {
+			r.Equal(5, len(dir.Directory.Content), dir.Name())
+			for _, child := range dir.Directory.Content {
+				r.Equal("content", child.File.Content)
+			}
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		r := require.New(t)
+
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}
+		rootChild.SetStorage(file.NewJSONStorage(filepath.Join(t.TempDir(), "not-exists", "lemonfs.json")))
+		root := inode.NewLemonInode(rootChild, nil)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Mkdir(filepath.Join(tmpDir, "a"), 0755)
+		r.ErrorIs(err, syscall.EIO)
+	})
+}
+
+func TestRollback(t *testing.T) {
+	// the target file can't be written, every change must be rolled back
+	newRoot := func(t *testing.T) (*inode.LemonInode, *file.LemonDirectory, *file.LemonFile) {
+		fileA := &file.LemonFile{
+			Type:           "file",
+			Name:           "a",
+			Content:        "hello",
+			LastModifiedAt: 100,
+		}
+
+		rootDir := &file.LemonDirectory{
+			Name: "root",
+			Type: "directory",
+			Content: []file.LemonDirectoryChild{
+				{Type: "file", File: fileA},
+				{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "b", Content: []file.LemonDirectoryChild{}}},
+			},
+		}
+
+		rootChild := &file.LemonDirectoryChild{
+			Type:      "directory",
+			Directory: rootDir,
+		}
+		rootChild.SetStorage(file.NewJSONStorage(filepath.Join(t.TempDir(), "not-exists", "lemonfs.json")))
+		root := inode.NewLemonInode(rootChild, nil)
+
+		return root, rootDir, fileA
+	}
+
+	t.Run("create", func(t *testing.T) {
+		r := require.New(t)
+		root, rootDir, _ := newRoot(t)
+		tmpDir := fusetest.Mount(t, root)
+
+		_, err := os.Create(filepath.Join(tmpDir, "c"))
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal(2, len(rootDir.Content))
+
+		_, err = os.Stat(filepath.Join(tmpDir, "c"))
+		r.True(os.IsNotExist(err))
+	})
+
+	t.Run("mkdir", func(t *testing.T) {
+		r := require.New(t)
+		root, rootDir, _ := newRoot(t)
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Mkdir(filepath.Join(tmpDir, "c"), 0755)
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal(2, len(rootDir.Content))
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		r := require.New(t)
+		root, rootDir, fileA := newRoot(t)
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b", "a"))
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal(2, len(rootDir.Content))
+		r.Equal("a", fileA.Name)
+		r.Equal(0, len(rootDir.Content[1].Directory.Content))
+
+		err = os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "c"))
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal("a", fileA.Name)
+	})
+
+	t.Run("unlink", func(t *testing.T) {
+		r := require.New(t)
+		root, rootDir, _ := newRoot(t)
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Remove(filepath.Join(tmpDir, "a"))
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal(2, len(rootDir.Content))
+
+		err = os.Remove(filepath.Join(tmpDir, "b"))
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal(2, len(rootDir.Content))
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		r := require.New(t)
+		root, _, fileA := newRoot(t)
+		tmpDir := fusetest.Mount(t, root)
+
+		_, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_TRUNC|os.O_WRONLY, 0644)
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal("hello", fileA.Content)
+
+		err = os.Truncate(filepath.Join(tmpDir, "a"), 2)
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal("hello", fileA.Content)
+		r.Equal(uint64(100), fileA.LastModifiedAt)
+	})
+
+	t.Run("setattr", func(t *testing.T) {
+		r := require.New(t)
+		root, _, fileA := newRoot(t)
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Chmod(filepath.Join(tmpDir, "a"), 0600)
+		r.ErrorIs(err, syscall.EIO)
+		r.Nil(fileA.Mode)
+
+		err = os.Chtimes(filepath.Join(tmpDir, "a"), time.Unix(1000, 0), time.Unix(1000, 0))
+		r.ErrorIs(err, syscall.EIO)
+		r.Equal(uint64(100), fileA.LastModifiedAt)
+	})
+}
+
+func TestWriteBack(t *testing.T) {
+	newRoot := func(t *testing.T, interval time.Duration) (*inode.LemonInode, string) {
+		targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+
+		rootChild := &file.LemonDirectoryChild{
+			Type: "directory",
+			Directory: &file.LemonDirectory{
+				Name:    "root",
+				Type:    "directory",
+				Content: []file.LemonDirectoryChild{},
+			},
+		}
+		storage := file.NewJSONStorage(targetFile)
+		file.NewWriteBack(storage, interval)
+		rootChild.SetStorage(storage)
+		t.Cleanup(func() { storage.Close() })
+
+		return inode.NewLemonInode(rootChild, nil), targetFile
+	}
+
+	load := func(r *require.Assertions, targetFile string) *file.LemonDirectoryChild {
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+
+		loaded := &file.LemonDirectoryChild{}
+		r.NoError(json.Unmarshal(jsonContent, loaded))
+		loaded.ApplyParent(nil)
+
+		return loaded
+	}
+
+	t.Run("flush on close", func(t *testing.T) {
+		r := require.New(t)
+
+		root, targetFile := newRoot(t, time.Hour)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.Create(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+
+		for range 10 {
+			_, err = f.Write([]byte("hello"))
+			r.NoError(err)
+		}
+
+		// nothing is written before the file is closed
+		_, err = os.Stat(targetFile)
+		r.True(os.IsNotExist(err))
+
+		r.NoError(f.Close())
+
+		loaded := load(r, targetFile)
+		r.Equal(1, len(loaded.Directory.Content))
+		r.Equal(strings.Repeat("hello", 10), loaded.Directory.Content[0].File.Content)
+	})
+
+	t.Run("flush on interval", func(t *testing.T) {
+		r := require.New(t)
+
+		root, targetFile := newRoot(t, 100*time.Millisecond)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		err := os.Mkdir(filepath.Join(tmpDir, "a"), 0755)
+		r.NoError(err)
+
+		r.Eventually(func() bool {
+			_, err := os.Stat(targetFile)
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond)
+
+		loaded := load(r, targetFile)
+		r.Equal(1, len(loaded.Directory.Content))
+		r.Equal("a", loaded.Directory.Content[0].Name())
+	})
+
+	t.Run("flush on fsync", func(t *testing.T) {
+		r := require.New(t)
+
+		root, targetFile := newRoot(t, time.Hour)
+
+		tmpDir := fusetest.Mount(t, root)
+
+		f, err := os.Create(filepath.Join(tmpDir, "a"))
+		r.NoError(err)
+		defer f.Close()
+
+		_, err = f.Write([]byte("hello"))
+		r.NoError(err)
+		r.NoError(f.Sync())
+
+		loaded := load(r, targetFile)
+		r.Equal("hello", loaded.Directory.Content[0].File.Content)
+	})
+}
+
+func TestJournal(t *testing.T) {
+	// replay loads the tree from the target file and replays the journal after a crash
+	replay := func(r *require.Assertions, targetFile string) (*file.LemonDirectoryChild, int) {
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+
+		root, err := file.NewJSONStorage(targetFile).Load()
+		r.NoError(err)
+
+		journal, err := file.OpenJournal(file.JournalPath(targetFile))
+		r.NoError(err)
+		defer journal.Close()
+
+		n, err := journal.Replay(root, jsonContent)
+		r.NoError(err)
+
+		return root, n
+	}
+
+	// mount mounts the tree in targetFile with a journal like cmd/lemonfs does, the write-back cache is never flushed by the timer
+	mount := func(r *require.Assertions, t *testing.T, targetFile string) (*file.LemonDirectoryChild, *file.WriteBack, string, func()) {
+		storage := file.NewJSONStorage(targetFile)
+		writeBack := file.NewWriteBack(storage, time.Hour)
+		journal, err := file.OpenJournal(file.JournalPath(targetFile))
+		r.NoError(err)
+		writeBack.SetJournal(journal)
+
+		root, err := storage.Load()
+		r.NoError(err)
+
+		tmpDir, server := fusetest.MountServer(t, inode.NewLemonInode(root, nil), nil)
+
+		return root, writeBack, tmpDir, func() { server.Unmount() }
+	}
+
+	newTarget := func(r *require.Assertions, t *testing.T) string {
+		targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+		err := os.WriteFile(targetFile, []byte(`{"type":"directory","name":"root","content":[]}`), 0644)
+		r.NoError(err)
+
+		return targetFile
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := newTarget(r, t)
+		root, _, tmpDir, unmount := mount(r, t, targetFile)
+
+		r.NoError(os.Mkdir(filepath.Join(tmpDir, "d"), 0700))
+		r.NoError(os.WriteFile(filepath.Join(tmpDir, "d", "a"), []byte("hello world"), 0644))
+
+		f, err := os.OpenFile(filepath.Join(tmpDir, "d", "a"), os.O_WRONLY, 0644)
+		r.NoError(err)
+		_, err = f.WriteAt([]byte("lemon"), 6)
+		r.NoError(err)
+		r.NoError(f.Close())
+
+		r.NoError(os.Rename(filepath.Join(tmpDir, "d", "a"), filepath.Join(tmpDir, "b")))
+		r.NoError(os.Chmod(filepath.Join(tmpDir, "b"), 0600))
+		r.NoError(os.Truncate(filepath.Join(tmpDir, "b"), 8))
+		r.NoError(os.Link(filepath.Join(tmpDir, "b"), filepath.Join(tmpDir, "d", "c")))
+		r.NoError(os.Symlink("b", filepath.Join(tmpDir, "e")))
+		r.NoError(os.WriteFile(filepath.Join(tmpDir, "f"), []byte("f"), 0644))
+		r.NoError(os.Remove(filepath.Join(tmpDir, "f")))
+		r.NoError(syscall.Setxattr(filepath.Join(tmpDir, "d"), "user.tag", []byte("lemon"), 0))
+
+		expected, err := json.Marshal(root)
+		r.NoError(err)
+
+		// crash without a checkpoint
+		unmount()
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+		r.NotContains(string(jsonContent), "hello")
+
+		replayed, n := replay(r, targetFile)
+		r.Greater(n, 0)
+
+		actual, err := json.Marshal(replayed)
+		r.NoError(err)
+		r.JSONEq(string(expected), string(actual))
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := newTarget(r, t)
+		_, writeBack, tmpDir, unmount := mount(r, t, targetFile)
+		defer unmount()
+
+		r.NoError(os.WriteFile(filepath.Join(tmpDir, "a"), []byte("hello"), 0644))
+		r.NoError(writeBack.Flush())
+
+		jsonContent, err := os.ReadFile(targetFile)
+		r.NoError(err)
+		r.Contains(string(jsonContent), "hello")
+
+		// the checkpointed changes are not replayed again
+		_, n := replay(r, targetFile)
+		r.Equal(0, n)
+	})
+
+	t.Run("torn record", func(t *testing.T) {
+		r := require.New(t)
+
+		targetFile := newTarget(r, t)
+		_, _, tmpDir, unmount := mount(r, t, targetFile)
+
+		r.NoError(os.Mkdir(filepath.Join(tmpDir, "a"), 0755))
+		unmount()
+
+		// a crash in the middle of appending a record
+		journalFile, err := os.OpenFile(file.JournalPath(targetFile), os.O_WRONLY|os.O_APPEND, 0644)
+		r.NoError(err)
+		_, err = journalFile.WriteString(`{"op":"mkdir","path":"/b"`)
+		r.NoError(err)
+		r.NoError(journalFile.Close())
+
+		root, _, tmpDir, unmount := mount(r, t, targetFile)
+		r.Equal(1, len(root.Directory.Content))
+		r.Equal("a", root.Directory.Content[0].Name())
+
+		// records appended after the torn one are replayed, every mkdir records the timestamps of the root too
+		r.NoError(os.Mkdir(filepath.Join(tmpDir, "c"), 0755))
+		unmount()
+
+		replayed, n := replay(r, targetFile)
+		r.Equal(4, n)
+		r.Equal(2, len(replayed.Directory.Content))
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	r := require.New(t)
+
+	targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+
+	fileA := &file.LemonFile{
+		Type:    "file",
+		Name:    "a",
+		Content: "hello",
+	}
+
+	rootDir := &file.LemonDirectory{
+		Name: "root",
+		Type: "directory",
+		Content: []file.LemonDirectoryChild{
+			{Type: "file", File: fileA},
+			{Type: "directory", Directory: &file.LemonDirectory{Type: "directory", Name: "b", Content: []file.LemonDirectoryChild{}}},
+		},
+	}
+
+	rootChild := &file.LemonDirectoryChild{
+		Type:      "directory",
+		Directory: rootDir,
+	}
+	rootChild.SetStorage(file.NewJSONStorage(targetFile))
+	root := inode.NewLemonInode(rootChild, nil)
+	root.Options.ReadOnly = true
+
+	// without the ro option, the operations reach lemonfs
+	tmpDir := fusetest.Mount(t, root)
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "a"))
+	r.NoError(err)
+	r.Equal("hello", string(content))
+
+	_, err = os.Create(filepath.Join(tmpDir, "c"))
+	r.ErrorIs(err, syscall.EROFS)
+
+	_, err = os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY, 0644)
+	r.ErrorIs(err, syscall.EROFS)
+
+	r.ErrorIs(os.Mkdir(filepath.Join(tmpDir, "c"), 0755), syscall.EROFS)
+	r.ErrorIs(os.Chmod(filepath.Join(tmpDir, "a"), 0600), syscall.EROFS)
+	r.ErrorIs(os.Truncate(filepath.Join(tmpDir, "a"), 0), syscall.EROFS)
+	r.ErrorIs(os.Rename(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "c")), syscall.EROFS)
+	r.ErrorIs(os.Remove(filepath.Join(tmpDir, "a")), syscall.EROFS)
+	r.ErrorIs(os.Remove(filepath.Join(tmpDir, "b")), syscall.EROFS)
+	r.ErrorIs(os.Symlink("a", filepath.Join(tmpDir, "c")), syscall.EROFS)
+	r.ErrorIs(os.Link(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "c")), syscall.EROFS)
+	r.ErrorIs(syscall.Setxattr(filepath.Join(tmpDir, "a"), "user.tag", []byte("lemon"), 0), syscall.EROFS)
+	r.ErrorIs(syscall.Access(filepath.Join(tmpDir, "a"), 2), syscall.EROFS)
+
+	r.Equal(2, len(rootDir.Content))
+	r.Equal("hello", fileA.Content)
+	r.Nil(fileA.Mode)
+
+	// nothing is written
+	_, err = os.Stat(targetFile)
+	r.True(os.IsNotExist(err))
+}
+
+func TestReload(t *testing.T) {
+	r := require.New(t)
+
+	targetFile := filepath.Join(t.TempDir(), "lemonfs.json")
+	write := func(children ...map[string]any) {
+		jsonContent, err := json.Marshal(map[string]any{"type": "directory", "name": "root", "content": children})
+		r.NoError(err)
+		r.NoError(os.WriteFile(targetFile, jsonContent, 0644))
+	}
+	newFile := func(name, content string) map[string]any {
+		return map[string]any{"type": "file", "name": name, "content": content}
+	}
+
+	write(newFile("a", "hello"), newFile("b", "lemon"), map[string]any{"type": "directory", "name": "d", "content": []any{}})
+
+	storage := file.NewJSONStorage(targetFile)
+	writeBack := file.NewWriteBack(storage, time.Hour)
+	defer storage.Close()
+
+	rootChild, err := storage.Load()
+	r.NoError(err)
+
+	root := inode.NewLemonInode(rootChild, nil)
+	watcher, err := file.NewWatcher(storage, rootChild, 20*time.Millisecond, root.Reloaded)
+	r.NoError(err)
+
+	tmpDir := fusetest.Mount(t, root)
+
+	watcher.Start()
+	defer watcher.Close()
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "a"))
+	r.NoError(err)
+	r.Equal("hello", string(content))
+	_, err = os.Stat(filepath.Join(tmpDir, "b"))
+	r.NoError(err)
+	_, err = os.Stat(filepath.Join(tmpDir, "c"))
+	r.True(os.IsNotExist(err))
+
+	// the kernel inode of a is kept, so an open file sees the new content
+	f, err := os.Open(filepath.Join(tmpDir, "a"))
+	r.NoError(err)
+	defer f.Close()
+
+	write(newFile("a", "world"), newFile("c", "new"), map[string]any{"type": "directory", "name": "d", "content": []any{newFile("e", "nested")}})
+
+	r.Eventually(func() bool {
+		content, err := os.ReadFile(filepath.Join(tmpDir, "c"))
+		return err == nil && string(content) == "new"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	buf := make([]byte, 5)
+	_, err = f.ReadAt(buf, 0)
+	r.NoError(err)
+	r.Equal("world", string(buf))
+
+	_, err = os.Stat(filepath.Join(tmpDir, "b"))
+	r.True(os.IsNotExist(err))
+
+	content, err = os.ReadFile(filepath.Join(tmpDir, "d", "e"))
+	r.NoError(err)
+	r.Equal("nested", string(content))
+
+	// changes written by lemonfs are not reloaded
+	r.NoError(os.WriteFile(filepath.Join(tmpDir, "c"), []byte("mine"), 0644))
+	r.NoError(writeBack.Flush())
+	r.NoError(os.WriteFile(filepath.Join(tmpDir, "c"), []byte("mine again"), 0644))
+	time.Sleep(100 * time.Millisecond)
+
+	content, err = os.ReadFile(filepath.Join(tmpDir, "c"))
+	r.NoError(err)
+	r.Equal("mine again", string(content))
+
+	// an invalid file is not loaded
+	r.NoError(os.WriteFile(targetFile, []byte("{"), 0644))
+	time.Sleep(100 * time.Millisecond)
+
+	content, err = os.ReadFile(filepath.Join(tmpDir, "a"))
+	r.NoError(err)
+	r.Equal("world", string(content))
+
+	// pending changes are neither lost nor written over the file changed by another process, closing the handle reports it
+	pending, err := os.OpenFile(filepath.Join(tmpDir, "a"), os.O_WRONLY|os.O_TRUNC, 0)
+	r.NoError(err)
+	defer pending.Close()
+	_, err = pending.WriteString("pending")
+	r.NoError(err)
+	write(newFile("a", "external"))
+	time.Sleep(100 * time.Millisecond)
+
+	content, err = os.ReadFile(filepath.Join(tmpDir, "a"))
+	r.NoError(err)
+	r.Equal("pending", string(content))
+	_, err = os.Stat(filepath.Join(tmpDir, "c"))
+	r.NoError(err)
+
+	r.ErrorIs(pending.Close(), syscall.EIO)
+	time.Sleep(100 * time.Millisecond)
+	r.ErrorIs(writeBack.Flush(), file.ErrConflict)
+
+	loaded, err := file.NewJSONStorage(targetFile).Load()
+	r.NoError(err)
+	r.Equal("external", loaded.Directory.Content[0].File.Content)
+}
+
+func TestOwnerOverride(t *testing.T) {
+	r := require.New(t)
+
+	owner := file.NewLemonPermission(0644, 1000, 1000)
+	fileA := &file.LemonFile{
+		LemonPermission: owner,
+
+		Type:    "file",
+		Name:    "a",
+		Content: "hello",
+	}
+
+	root := inode.NewLemonInode(&file.LemonDirectoryChild{
+		Type: "directory",
+		Directory: &file.LemonDirectory{
+			Name:    "root",
+			Type:    "directory",
+			Content: []file.LemonDirectoryChild{{Type: "file", File: fileA}},
+		},
+	}, nil)
+	uid, gid := uint32(1234), uint32(5678)
+	root.Options.Uid = &uid
+	root.Options.Gid = &gid
+
+	tmpDir := fusetest.Mount(t, root)
+
+	for _, path := range []string{tmpDir, filepath.Join(tmpDir, "a")} {
+		info, err := os.Stat(path)
+		r.NoError(err)
+		r.Equal(uid, info.Sys().(*syscall.Stat_t).Uid)
+		r.Equal(gid, info.Sys().(*syscall.Stat_t).Gid)
+	}
+
+	// the owner in the tree is kept
+	r.Equal(uint32(1000), *fileA.Uid)
+	r.Equal(uint32(1000), *fileA.Gid)
+}
+
+func TestMemoryStorage(t *testing.T) {
+	r := require.New(t)
+
+	storage := file.NewMemoryStorage(nil)
+	rootChild, err := storage.Load()
+	r.NoError(err)
+
+	tmpDir := fusetest.Mount(t, inode.NewLemonInode(rootChild, nil))
+
+	r.NoError(os.Mkdir(filepath.Join(tmpDir, "d"), 0755))
+	r.NoError(os.WriteFile(filepath.Join(tmpDir, "d", "a"), []byte("hello"), 0644))
+	r.NoError(os.Rename(filepath.Join(tmpDir, "d", "a"), filepath.Join(tmpDir, "b")))
+	r.NoError(os.Remove(filepath.Join(tmpDir, "b")))
+
+	ops := []string{}
+	for _, change := range storage.Changes() {
+		ops = append(ops, change.Op+" "+change.Path)
+	}
+	r.Equal([]string{
+		"mkdir /d", "setattr /",
+		"create /d/a", "setattr /d",
+		"write /d/a",
+		"rename /d/a", "setattr /d", "setattr /",
+		"delete /b", "setattr /",
+	}, ops)
+
+	// a change the storage fails to persist is rolled back
+	storage.Fail(syscall.ENOSPC)
+	r.ErrorIs(os.Mkdir(filepath.Join(tmpDir, "e"), 0755), syscall.ENOSPC)
+	r.Equal(1, len(rootChild.Directory.Content))
+
+	storage.Fail(nil)
+	r.NoError(os.Mkdir(filepath.Join(tmpDir, "e"), 0755))
+	r.Equal(2, len(rootChild.Directory.Content))
+}
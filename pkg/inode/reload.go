@@ -0,0 +1,61 @@
+package inode
+
+import (
+	"log"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// Reloaded invalidates the caches of the kernel after the tree of the root inode i has been reloaded by a file.Watcher.
+// Added and removed entries are invalidated in their parent, the kernel inodes of removed entries are forgotten,
+// and the attributes and content of changed nodes are invalidated.
+func (i *LemonInode) Reloaded(differences []file.Difference) {
+	for _, difference := range differences {
+		log.Printf("Reloaded %s, kind: %d", difference.Path, difference.Kind)
+
+		if difference.Kind == file.DifferenceChanged {
+			if node := i.lookupPath(difference.Path); node != nil {
+				// the kernel may not have cached it
+				_ = node.NotifyContent(0, 0)
+			}
+			continue
+		}
+
+		parentPath, name := splitPath(difference.Path)
+		parent := i.lookupPath(parentPath)
+		if parent == nil {
+			continue
+		}
+
+		_ = parent.NotifyEntry(name)
+		if difference.Kind == file.DifferenceRemoved {
+			parent.RmChild(name)
+		}
+	}
+}
+
+// lookupPath returns the kernel inode of path below i, or nil if the kernel hasn't looked it up
+func (i *LemonInode) lookupPath(path string) *fs.Inode {
+	node := i.EmbeddedInode()
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		node = node.GetChild(name)
+		if node == nil {
+			return nil
+		}
+	}
+
+	return node
+}
+
+// splitPath splits an absolute path into its parent and its name
+func splitPath(path string) (string, string) {
+	index := strings.LastIndex(path, "/")
+
+	return path[:index], path[index+1:]
+}
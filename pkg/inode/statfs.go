@@ -0,0 +1,54 @@
+package inode
+
+import (
+	"context"
+	"log"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+const (
+	// DefaultCapacity is the capacity reported if Options.Capacity is not set
+	DefaultCapacity uint64 = 1 << 30
+
+	statfsBlockSize uint32 = 4096
+	statfsNameMax   uint32 = 255
+)
+
+func (i *LemonInode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	i.rwLock.RLock()
+	defer i.rwLock.RUnlock()
+
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
+	log.Println("Statfs", i.Content.Path())
+
+	used, nodes, err := i.Content.Usage()
+	if err != nil {
+		log.Printf("Failed to get the size of the tree: %v", err)
+		return syscall.EIO
+	}
+
+	capacity := i.Options.Capacity
+	if capacity == 0 {
+		capacity = DefaultCapacity
+	}
+
+	blockSize := uint64(statfsBlockSize)
+	blocks := capacity / blockSize
+	usedBlocks := min((used+blockSize-1)/blockSize, blocks)
+
+	out.Bsize = statfsBlockSize
+	out.Frsize = statfsBlockSize
+	out.Blocks = blocks
+	out.Bfree = blocks - usedBlocks
+	out.Bavail = blocks - usedBlocks
+	// every node takes at least a few bytes of JSON, assume one node per free block
+	out.Ffree = out.Bfree
+	out.Files = nodes + out.Ffree
+	out.NameLen = statfsNameMax
+
+	return 0
+}
@@ -0,0 +1,136 @@
+package inode
+
+import (
+	"context"
+	"log"
+	"strings"
+	"syscall"
+
+	"github.com/lemonnekogh/lemonfs/pkg/file"
+)
+
+// flags of setxattr(2)
+const (
+	xattrCreate  uint32 = 1
+	xattrReplace uint32 = 2
+)
+
+func (i *LemonInode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	i.rwLock.RLock()
+	defer i.rwLock.RUnlock()
+
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
+	log.Printf("Getxattr %s of %s, %d bytes", attr, i.Content.Path(), len(dest))
+
+	if errno := i.checkAccess(ctx, i.Content, maskRead); errno != 0 {
+		return 0, errno
+	}
+
+	value, ok := i.Content.GetXattr(attr)
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+
+	return uint32(copy(dest, value)), 0
+}
+
+func (i *LemonInode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
+	i.rwLock.Lock()
+	defer i.rwLock.Unlock()
+
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	log.Printf("Setxattr %s of %s, %d bytes, flags %d", attr, i.Content.Path(), len(data), flags)
+
+	if i.Options.ReadOnly {
+		return syscall.EROFS
+	}
+
+	if !i.Content.SupportsXattrs() {
+		return syscall.EPERM
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite); errno != 0 {
+		return errno
+	}
+
+	_, exists := i.Content.GetXattr(attr)
+	if flags&xattrCreate != 0 && exists {
+		return syscall.EEXIST
+	}
+
+	if flags&xattrReplace != 0 && !exists {
+		return syscall.ENODATA
+	}
+
+	snapshot := i.Content.Snapshot()
+	i.Content.SetXattr(attr, data)
+	if err := i.Content.Persist(file.SetattrChange(i.Content)); err != nil {
+		return file.Rollback(err, snapshot)
+	}
+
+	return 0
+}
+
+func (i *LemonInode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	i.rwLock.RLock()
+	defer i.rwLock.RUnlock()
+
+	i.Content.RLockTree()
+	defer i.Content.RUnlockTree()
+
+	log.Printf("Listxattr of %s, %d bytes", i.Content.Path(), len(dest))
+
+	if errno := i.checkAccess(ctx, i.Content, maskRead); errno != 0 {
+		return 0, errno
+	}
+
+	// every name is terminated by a null byte
+	names := strings.Builder{}
+	for _, name := range i.Content.XattrNames() {
+		names.WriteString(name)
+		names.WriteByte(0)
+	}
+
+	if len(dest) < names.Len() {
+		return uint32(names.Len()), syscall.ERANGE
+	}
+
+	return uint32(copy(dest, names.String())), 0
+}
+
+func (i *LemonInode) Removexattr(ctx context.Context, attr string) syscall.Errno {
+	i.rwLock.Lock()
+	defer i.rwLock.Unlock()
+
+	i.Content.LockTree()
+	defer i.Content.UnlockTree()
+
+	log.Printf("Removexattr %s of %s", attr, i.Content.Path())
+
+	if i.Options.ReadOnly {
+		return syscall.EROFS
+	}
+
+	if errno := i.checkAccess(ctx, i.Content, maskWrite); errno != 0 {
+		return errno
+	}
+
+	snapshot := i.Content.Snapshot()
+	if !i.Content.RemoveXattr(attr) {
+		return syscall.ENODATA
+	}
+
+	if err := i.Content.Persist(file.SetattrChange(i.Content)); err != nil {
+		return file.Rollback(err, snapshot)
+	}
+
+	return 0
+}